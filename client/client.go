@@ -4,24 +4,122 @@ import (
 	"context"
 	"fmt"
 	"github.com/infiotinc/gqlgenc/client/transport"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+type extension struct {
+	Extension
+	priority int
+}
+
+// extensions are sorted by decreasing priority, then registration order
+// The list is copied on write, so that the running requests are not affected
 type extensions struct {
-	aroundRequest []AroundRequest
+	list []extension
+	m    sync.RWMutex
 }
 
+// Use registers e with the priority 0, see UseWithPriority
 func (es *extensions) Use(e Extension) {
-	if a, ok := e.(AroundRequest); ok {
-		es.aroundRequest = append(es.aroundRequest, a)
+	es.UseWithPriority(e, 0)
+}
+
+// UseWithPriority registers e, the extensions run by decreasing priority, then in their registration order:
+// the first one sees the request first (BeforeRequest, AroundRequest), and the responses last (AroundResponse, OnError)
+func (es *extensions) UseWithPriority(e Extension, priority int) {
+	es.m.Lock()
+	defer es.m.Unlock()
+
+	i := sort.Search(len(es.list), func(i int) bool {
+		return es.list[i].priority < priority
+	})
+
+	list := make([]extension, 0, len(es.list)+1)
+	list = append(list, es.list[:i]...)
+	list = append(list, extension{Extension: e, priority: priority})
+	list = append(list, es.list[i:]...)
+	es.list = list
+}
+
+// Extensions returns the registered extensions, in their running order
+func (es *extensions) Extensions() []Extension {
+	list := es.snapshot()
+
+	exts := make([]Extension, 0, len(list))
+	for _, e := range list {
+		exts = append(exts, e.Extension)
 	}
+
+	return exts
+}
+
+// RemoveExtension unregisters the extensions named name, it reports whether there was any
+func (es *extensions) RemoveExtension(name string) bool {
+	es.m.Lock()
+	defer es.m.Unlock()
+
+	list := make([]extension, 0, len(es.list))
+	for _, e := range es.list {
+		if e.ExtensionName() != name {
+			list = append(list, e)
+		}
+	}
+
+	removed := len(list) != len(es.list)
+	es.list = list
+
+	return removed
+}
+
+func (es *extensions) snapshot() []extension {
+	es.m.RLock()
+	defer es.m.RUnlock()
+
+	return es.list
 }
 
+// RunAroundRequest runs req through the AroundRequest extensions only, down to h
 func (es *extensions) RunAroundRequest(req transport.Request, h RequestHandler) transport.Response {
+	return runAroundRequest(es.snapshot(), req, h)
+}
+
+// run runs req through all the extensions, down to h
+func (es *extensions) run(req transport.Request, h RequestHandler) transport.Response {
+	exts := es.snapshot()
+
+	var res transport.Response
+	for _, e := range exts {
+		if b, ok := e.Extension.(BeforeRequest); ok {
+			var err error
+			req, err = b.BeforeRequest(req)
+			if err != nil {
+				res = transport.NewErrorResponse(err)
+				break
+			}
+		}
+	}
+
+	if res == nil {
+		res = runAroundRequest(exts, req, h)
+	}
+
+	return runAroundResponse(exts, req, res)
+}
+
+func runAroundRequest(exts []extension, req transport.Request, h RequestHandler) transport.Response {
 	run := h
 
-	for _, _e := range es.aroundRequest {
-		e := _e // Local ref
-		next := run // Local ref
+	// The first extension ends up outermost
+	for i := len(exts) - 1; i >= 0; i-- {
+		e, ok := exts[i].Extension.(AroundRequest)
+		if !ok {
+			continue
+		}
+
+		next := run
 		run = func(req transport.Request) transport.Response {
 			return e.AroundRequest(req, next)
 		}
@@ -30,8 +128,69 @@ func (es *extensions) RunAroundRequest(req transport.Request, h RequestHandler)
 	return run(req)
 }
 
+func runAroundResponse(exts []extension, req transport.Request, res transport.Response) transport.Response {
+	var arounds []AroundResponse
+	var onErrors []OnError
+
+	// The responses go through the last extension first
+	for i := len(exts) - 1; i >= 0; i-- {
+		if e, ok := exts[i].Extension.(AroundResponse); ok {
+			arounds = append(arounds, e)
+		}
+		if e, ok := exts[i].Extension.(OnError); ok {
+			onErrors = append(onErrors, e)
+		}
+	}
+
+	if len(arounds) == 0 && len(onErrors) == 0 {
+		return res
+	}
+
+	var closed int32
+	nres := transport.NewChanResponse(func() error {
+		atomic.StoreInt32(&closed, 1)
+		res.Close()
+		return res.Err()
+	})
+
+	send := ResponseHandler(nres.Send)
+	for i := len(arounds) - 1; i >= 0; i-- {
+		e := arounds[i]
+		next := send
+		send = func(opres transport.OperationResponse) {
+			e.AroundResponse(req, opres, next)
+		}
+	}
+
+	go func() {
+		for res.Next() {
+			send(res.Get())
+		}
+
+		err := res.Err()
+		if err == nil {
+			nres.CloseCh()
+			return
+		}
+
+		if atomic.LoadInt32(&closed) == 0 {
+			for _, e := range onErrors {
+				e.OnError(req, err)
+			}
+		}
+		nres.CloseWithError(err)
+	}()
+
+	return nres
+}
+
 type Client struct {
 	Transport transport.Transport
+	// Logger receives the operations start (debug) and end (debug, warn when failed or with GraphQL errors) events,
+	// with their name, variables, duration and errors. Nothing is logged when nil
+	Logger transport.Logger
+	// LogRedaction hides variables from the logged operations
+	LogRedaction transport.Redaction
 
 	extensions
 }
@@ -77,7 +236,10 @@ func (c *Client) do(req transport.Request) transport.Response {
 		req.Extensions = map[string]interface{}{}
 	}
 
-	res := c.RunAroundRequest(req, c.Transport.Request)
+	res := c.run(req, c.Transport.Request)
+	if c.Logger != nil {
+		res = c.logResponse(req, res)
+	}
 
 	go func() {
 		select {
@@ -90,6 +252,49 @@ func (c *Client) do(req transport.Request) transport.Response {
 	return res
 }
 
+// logResponse logs req, and res once done
+func (c *Client) logResponse(req transport.Request, res transport.Response) transport.Response {
+	c.Logger.Log(req.Context, transport.LogLevelDebug, "graphql operation",
+		"operation", req.Operation,
+		"operationName", req.OperationName,
+		"variables", c.LogRedaction.RedactVariables(req.Variables),
+	)
+
+	start := time.Now()
+	var responses, errs int64
+
+	nres := transport.NewProxyResponse()
+	nres.Bind(res, func(opres transport.OperationResponse, send func()) {
+		atomic.AddInt64(&responses, 1)
+		atomic.AddInt64(&errs, int64(len(opres.Errors)))
+		send()
+	})
+
+	go func() {
+		<-nres.Done()
+
+		args := []interface{}{
+			"operation", req.Operation,
+			"operationName", req.OperationName,
+			"duration", time.Since(start),
+			"responses", atomic.LoadInt64(&responses),
+			"errors", atomic.LoadInt64(&errs),
+		}
+
+		level := transport.LogLevelDebug
+		if err := nres.Err(); err != nil {
+			level = transport.LogLevelWarn
+			args = append(args, "error", err)
+		} else if atomic.LoadInt64(&errs) > 0 {
+			level = transport.LogLevelWarn
+		}
+
+		c.Logger.Log(req.Context, level, "graphql operation done", args...)
+	}()
+
+	return nres
+}
+
 // Query runs a query
 // operationName is optional
 func (c *Client) Query(ctx context.Context, operationName string, query string, variables map[string]interface{}, t interface{}) (transport.OperationResponse, error) {
@@ -113,3 +318,16 @@ func (c *Client) Subscription(ctx context.Context, operationName string, query s
 		Variables:     variables,
 	})
 }
+
+// IncrementalQuery runs a query using @defer or @stream, the initial response and the subsequent payloads
+// are received as successive responses
+// operationName is optional
+func (c *Client) IncrementalQuery(ctx context.Context, operationName string, query string, variables map[string]interface{}) transport.Response {
+	return c.do(transport.Request{
+		Context:       ctx,
+		Operation:     transport.Query,
+		Query:         query,
+		OperationName: operationName,
+		Variables:     variables,
+	})
+}
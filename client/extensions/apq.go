@@ -37,6 +37,7 @@ func (a *APQ) AroundRequest(req transport.Request, next client.RequestHandler) t
 		OperationName: req.OperationName,
 		Variables:     req.Variables,
 		Extensions:    req.Extensions,
+		Options:       req.Options,
 	})
 
 	nres := transport.NewProxyResponse()
@@ -0,0 +1,692 @@
+package extensions
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachePolicy decides how a query is answered by the Cache
+type CachePolicy int
+
+const (
+	// CacheFirst answers from the cache when the query result is fresh, from the network otherwise
+	CacheFirst CachePolicy = iota
+	// NetworkOnly always answers from the network, the result is cached
+	NetworkOnly
+	// CacheAndNetwork answers from the cache if it can, then from the network: the response holds up to 2 results
+	CacheAndNetwork
+	// StaleWhileRevalidate answers from the cache, even if the result is stale, in which case it is refreshed
+	// in the background. It answers from the network on a miss
+	StaleWhileRevalidate
+)
+
+type cachePolicyKey struct{}
+
+// WithCachePolicy overrides the Cache policy of the queries requested with the returned context
+func WithCachePolicy(ctx context.Context, policy CachePolicy) context.Context {
+	return context.WithValue(ctx, cachePolicyKey{}, policy)
+}
+
+// Cache is a normalized response cache: objects with a __typename and key fields are stored once as entities,
+// shared by the query results referencing them, and updated by the mutations results
+// Queries must select __typename (and the key fields) for their objects to be normalized, the entities fields are
+// stored under their name and arguments, so that aliases and arguments do not clash between queries
+// Only the results without errors are cached, subscriptions are not. Requests with headers or cookies
+// request options (ie: a tenant or credentials) bypass the cache
+// The entities are kept as long as a cached query result references them
+type Cache struct {
+	// Policy defaults to CacheFirst
+	Policy CachePolicy
+	// MaxAge is how long a query result is fresh, 0 means forever. Stale results are kept for StaleWhileRevalidate,
+	// until evicted by MaxResults
+	MaxAge time.Duration
+	// MaxResults is the number of query results kept, the least recently used ones are evicted first
+	// Defaults to 1000, < 0 means no limit
+	MaxResults int
+	// KeyFields are the fields identifying the objects of a type, defaults to []string{"id"}
+	// A type with no key fields is not normalized, it is stored in the results referencing it
+	KeyFields map[string][]string
+
+	entities map[string]cacheEntity
+	// refs counts the results referencing each entity
+	refs    map[string]int
+	results map[string]*cacheResult
+	// lru orders the results keys, the most recently used first
+	lru *list.List
+	m   sync.Mutex
+}
+
+var _ client.AroundRequest = (*Cache)(nil)
+
+type cacheEntity map[string]json.RawMessage
+
+type cacheResult struct {
+	root     *cacheNode
+	refs     []string
+	storedAt time.Time
+	elem     *list.Element
+}
+
+// cacheNode is a node of a normalized result: either a leaf Value, a list of Items, an object of Fields
+// (by response key), or an entity Field of the object Ref
+type cacheNode struct {
+	Value  json.RawMessage
+	Items  []*cacheNode
+	Fields map[string]*cacheNode
+	Ref    string
+	Field  string
+}
+
+// cacheSelection maps the response keys of a selection set to the fields they select
+type cacheSelection map[string]*cacheField
+
+type cacheField struct {
+	// key is the field name, followed by its arguments if any (ie: avatar({"size":10})),
+	// it is empty when the response key selects different fields (ie: from inline fragments on different types)
+	key       string
+	selection cacheSelection
+}
+
+func (c *Cache) ExtensionName() string {
+	return "cache"
+}
+
+func (c *Cache) maxResults() int {
+	if c.MaxResults == 0 {
+		return 1000
+	}
+
+	return c.MaxResults
+}
+
+func (c *Cache) policy(ctx context.Context) CachePolicy {
+	if policy, ok := ctx.Value(cachePolicyKey{}).(CachePolicy); ok {
+		return policy
+	}
+
+	return c.Policy
+}
+
+// Clear removes all the entities and results
+func (c *Cache) Clear() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.entities = nil
+	c.refs = nil
+	c.results = nil
+	c.lru = nil
+}
+
+// Evict removes an entity, the results referencing it are no longer answered from the cache
+// key is the typename and the key fields values joined by ":" (ie: User:1)
+func (c *Cache) Evict(key string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	delete(c.entities, key)
+}
+
+// Entity returns the cached fields of an entity, by name, followed by their arguments if any (ie: avatar({"size":10}))
+func (c *Cache) Entity(key string) (map[string]json.RawMessage, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, ok := c.entities[key]
+	if !ok {
+		return nil, false
+	}
+
+	fields := make(map[string]json.RawMessage, len(e))
+	for k, v := range e {
+		fields[k] = v
+	}
+
+	return fields, true
+}
+
+func (c *Cache) AroundRequest(req transport.Request, next client.RequestHandler) transport.Response {
+	if hasHeaderOptions(req) {
+		return next(req)
+	}
+
+	switch req.Operation {
+	case transport.Query:
+	case transport.Mutation:
+		return c.fetch(req, next, "")
+	default:
+		return next(req)
+	}
+
+	key, err := resultKey(req)
+	if err != nil {
+		return next(req)
+	}
+
+	policy := c.policy(req.Context)
+	if policy == NetworkOnly {
+		return c.fetch(req, next, key)
+	}
+
+	data, fresh, ok := c.read(key)
+	if !ok || (!fresh && policy != StaleWhileRevalidate) {
+		return c.fetch(req, next, key)
+	}
+
+	cached := transport.OperationResponse{Data: data}
+
+	switch {
+	case policy == CacheAndNetwork:
+		res := transport.NewChanResponse(nil)
+		go func() {
+			defer res.CloseCh()
+
+			res.Send(cached)
+
+			nres := c.fetch(req, next, key)
+			defer nres.Close()
+
+			for nres.Next() {
+				res.Send(nres.Get())
+			}
+
+			if err := nres.Err(); err != nil {
+				res.CloseWithError(err)
+			}
+		}()
+
+		return res
+	case !fresh:
+		// The caller context is usually canceled as soon as the cached result is read
+		rreq := req
+		rreq.Context = detachedContext{parent: req.Context}
+		go c.revalidate(rreq, next, key)
+	}
+
+	return transport.NewSingleResponse(cached)
+}
+
+// revalidate refreshes the result in the background
+func (c *Cache) revalidate(req transport.Request, next client.RequestHandler, key string) {
+	res := c.fetch(req, next, key)
+	defer res.Close()
+
+	for res.Next() {
+		// Cached by fetch
+	}
+}
+
+// fetch requests the network, and caches the result, under key if not empty
+func (c *Cache) fetch(req transport.Request, next client.RequestHandler, key string) transport.Response {
+	res := next(req)
+
+	nres := transport.NewProxyResponse()
+	nres.Bind(res, func(opres transport.OperationResponse, send func()) {
+		if len(opres.Errors) == 0 && !opres.HasNext && !opres.IsIncremental() && len(opres.Data) > 0 {
+			// Without its selection, the result is still cached, but not normalized
+			sel, _ := parseSelection(req)
+			c.write(key, opres.Data, sel)
+		}
+
+		send()
+	})
+
+	return nres
+}
+
+// hasHeaderOptions returns true if req has headers or cookies request options, which may change its result
+func hasHeaderOptions(req transport.Request) bool {
+	if len(req.Options.Header) > 0 || len(req.Options.Cookies) > 0 {
+		return true
+	}
+
+	if opts, ok := transport.RequestOptionsFromContext(req.Context); ok {
+		return len(opts.Header) > 0 || len(opts.Cookies) > 0
+	}
+
+	return false
+}
+
+// parseSelection returns the selection of the operation of req
+func parseSelection(req transport.Request) (cacheSelection, error) {
+	doc, gerr := parser.ParseQuery(&ast.Source{Input: req.Query})
+	if gerr != nil {
+		return nil, gerr
+	}
+
+	var op *ast.OperationDefinition
+	if req.OperationName == "" && len(doc.Operations) == 1 {
+		op = doc.Operations[0]
+	} else {
+		op = doc.Operations.ForName(req.OperationName)
+	}
+	if op == nil {
+		return nil, fmt.Errorf("operation %q not found", req.OperationName)
+	}
+
+	sel := cacheSelection{}
+	if err := sel.add(doc, op.SelectionSet, req.Variables, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	return sel, nil
+}
+
+// add merges set into s, spreading its fragments
+func (s cacheSelection) add(doc *ast.QueryDocument, set ast.SelectionSet, vars map[string]interface{}, spreading map[string]bool) error {
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			key, err := fieldKey(sel, vars)
+			if err != nil {
+				return err
+			}
+
+			f, ok := s[sel.Alias]
+			if !ok {
+				f = &cacheField{key: key}
+				s[sel.Alias] = f
+			} else if f.key != key {
+				f.key = ""
+			}
+
+			if len(sel.SelectionSet) > 0 {
+				if f.selection == nil {
+					f.selection = cacheSelection{}
+				}
+				if err := f.selection.add(doc, sel.SelectionSet, vars, spreading); err != nil {
+					return err
+				}
+			}
+		case *ast.InlineFragment:
+			if err := s.add(doc, sel.SelectionSet, vars, spreading); err != nil {
+				return err
+			}
+		case *ast.FragmentSpread:
+			frag := doc.Fragments.ForName(sel.Name)
+			if frag == nil {
+				return fmt.Errorf("fragment %q not found", sel.Name)
+			}
+			if spreading[sel.Name] {
+				return fmt.Errorf("fragment %q spreads itself", sel.Name)
+			}
+
+			spreading[sel.Name] = true
+			err := s.add(doc, frag.SelectionSet, vars, spreading)
+			delete(spreading, sel.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldKey returns the name of f, followed by its arguments values if any
+func fieldKey(f *ast.Field, vars map[string]interface{}) (string, error) {
+	if len(f.Arguments) == 0 {
+		return f.Name, nil
+	}
+
+	args := make(map[string]interface{}, len(f.Arguments))
+	for _, arg := range f.Arguments {
+		v, err := arg.Value.Value(vars)
+		if err != nil {
+			return "", err
+		}
+		args[arg.Name] = v
+	}
+
+	// encoding/json sorts the maps keys
+	b, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%v(%s)", f.Name, b), nil
+}
+
+// lookup returns the value of the field key (ie: id) in obj, whatever its response key
+func (s cacheSelection) lookup(obj map[string]json.RawMessage, key string) (json.RawMessage, bool) {
+	for rk, f := range s {
+		if f.key != key {
+			continue
+		}
+
+		if v, ok := obj[rk]; ok {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+func resultKey(req transport.Request) (string, error) {
+	vars, err := json.Marshal(req.Variables)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(req.OperationName + "\n" + req.Query + "\n" + string(vars)))
+
+	return fmt.Sprintf("%x", sum), nil
+}
+
+func (c *Cache) write(key string, data json.RawMessage, sel cacheSelection) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.entities == nil {
+		c.entities = map[string]cacheEntity{}
+	}
+
+	root, err := c.normalize(data, sel)
+	if err != nil {
+		return
+	}
+
+	refs := root.entityRefs()
+
+	if key == "" {
+		// The entities of a mutation result are only kept if a query result references them
+		for _, ref := range refs {
+			if c.refs[ref] == 0 {
+				delete(c.entities, ref)
+			}
+		}
+		return
+	}
+
+	if c.results == nil {
+		c.results = map[string]*cacheResult{}
+		c.refs = map[string]int{}
+		c.lru = list.New()
+	}
+
+	// Counts the new references first, so that the entities shared with the previous result are kept
+	for _, ref := range refs {
+		c.refs[ref]++
+	}
+
+	if prev, ok := c.results[key]; ok {
+		c.removeResult(key, prev)
+	}
+
+	c.results[key] = &cacheResult{root: root, refs: refs, storedAt: time.Now(), elem: c.lru.PushFront(key)}
+
+	if max := c.maxResults(); max > 0 {
+		for len(c.results) > max {
+			lkey := c.lru.Back().Value.(string)
+			c.removeResult(lkey, c.results[lkey])
+		}
+	}
+}
+
+// removeResult removes the result stored under key, and the entities only it references
+func (c *Cache) removeResult(key string, res *cacheResult) {
+	delete(c.results, key)
+	c.lru.Remove(res.elem)
+
+	for _, ref := range res.refs {
+		c.refs[ref]--
+		if c.refs[ref] <= 0 {
+			delete(c.refs, ref)
+			delete(c.entities, ref)
+		}
+	}
+}
+
+// read returns the result stored under key, and whether it is fresh
+func (c *Cache) read(key string) (json.RawMessage, bool, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	res, ok := c.results[key]
+	if !ok {
+		return nil, false, false
+	}
+	c.lru.MoveToFront(res.elem)
+
+	v, ok := c.denormalize(res.root)
+	if !ok {
+		return nil, false, false
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, false, false
+	}
+
+	fresh := c.MaxAge <= 0 || time.Since(res.storedAt) < c.MaxAge
+
+	return data, fresh, true
+}
+
+// entityKey returns the key of the object, if it can be normalized
+func (c *Cache) entityKey(obj map[string]json.RawMessage, sel cacheSelection) (string, bool) {
+	if sel == nil {
+		return "", false
+	}
+
+	tv, _ := sel.lookup(obj, "__typename")
+
+	var typename string
+	if err := json.Unmarshal(tv, &typename); err != nil || typename == "" {
+		return "", false
+	}
+
+	fields, ok := c.KeyFields[typename]
+	if !ok {
+		fields = []string{"id"}
+	}
+
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	parts := make([]string, 0, len(fields)+1)
+	parts = append(parts, typename)
+	for _, f := range fields {
+		v, ok := sel.lookup(obj, f)
+		if !ok || isNull(v) {
+			return "", false
+		}
+
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			parts = append(parts, s)
+		} else {
+			parts = append(parts, string(v))
+		}
+	}
+
+	return strings.Join(parts, ":"), true
+}
+
+func isNull(v json.RawMessage) bool {
+	return len(v) == 0 || string(bytes.TrimSpace(v)) == "null"
+}
+
+func firstByte(v json.RawMessage) byte {
+	v = bytes.TrimSpace(v)
+	if len(v) == 0 {
+		return 0
+	}
+
+	return v[0]
+}
+
+// isLeaf returns true if v contains no object
+func isLeaf(v json.RawMessage) bool {
+	switch firstByte(v) {
+	case '{':
+		return false
+	case '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(v, &items); err != nil {
+			return true
+		}
+
+		for _, item := range items {
+			if !isLeaf(item) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// normalize stores the entities found in v, and returns the node of v
+// sel is the selection of v, the objects without a selection are not normalized
+func (c *Cache) normalize(v json.RawMessage, sel cacheSelection) (*cacheNode, error) {
+	if isLeaf(v) {
+		return &cacheNode{Value: v}, nil
+	}
+
+	if firstByte(v) == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(v, &items); err != nil {
+			return nil, err
+		}
+
+		node := &cacheNode{Items: make([]*cacheNode, 0, len(items))}
+		for _, item := range items {
+			n, err := c.normalize(item, sel)
+			if err != nil {
+				return nil, err
+			}
+			node.Items = append(node.Items, n)
+		}
+
+		return node, nil
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(v, &obj); err != nil {
+		return nil, err
+	}
+
+	node := &cacheNode{Fields: make(map[string]*cacheNode, len(obj))}
+
+	var entity cacheEntity
+	if key, ok := c.entityKey(obj, sel); ok {
+		node.Ref = key
+
+		entity = c.entities[key]
+		if entity == nil {
+			entity = cacheEntity{}
+			c.entities[key] = entity
+		}
+	}
+
+	for k, fv := range obj {
+		f := sel[k]
+
+		if entity != nil && f != nil && f.key != "" && isLeaf(fv) {
+			entity[f.key] = fv
+			node.Fields[k] = &cacheNode{Field: f.key}
+			continue
+		}
+
+		var fsel cacheSelection
+		if f != nil {
+			fsel = f.selection
+		}
+
+		n, err := c.normalize(fv, fsel)
+		if err != nil {
+			return nil, err
+		}
+		node.Fields[k] = n
+	}
+
+	return node, nil
+}
+
+// entityRefs returns the distinct entities referenced by node and its children
+func (n *cacheNode) entityRefs() []string {
+	set := map[string]struct{}{}
+	n.collectRefs(set)
+
+	refs := make([]string, 0, len(set))
+	for ref := range set {
+		refs = append(refs, ref)
+	}
+
+	return refs
+}
+
+func (n *cacheNode) collectRefs(set map[string]struct{}) {
+	if n.Ref != "" {
+		set[n.Ref] = struct{}{}
+	}
+
+	for _, item := range n.Items {
+		item.collectRefs(set)
+	}
+
+	for _, f := range n.Fields {
+		f.collectRefs(set)
+	}
+}
+
+// denormalize rebuilds the value of node, it fails if a referenced entity or field is missing
+func (c *Cache) denormalize(node *cacheNode) (interface{}, bool) {
+	switch {
+	case node.Value != nil:
+		return node.Value, true
+	case node.Items != nil:
+		items := make([]interface{}, 0, len(node.Items))
+		for _, n := range node.Items {
+			v, ok := c.denormalize(n)
+			if !ok {
+				return nil, false
+			}
+			items = append(items, v)
+		}
+
+		return items, true
+	}
+
+	var entity cacheEntity
+	if node.Ref != "" {
+		var ok bool
+		entity, ok = c.entities[node.Ref]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	obj := make(map[string]interface{}, len(node.Fields))
+	for k, n := range node.Fields {
+		if n.Field != "" {
+			v, ok := entity[n.Field]
+			if !ok {
+				return nil, false
+			}
+			obj[k] = v
+			continue
+		}
+
+		v, ok := c.denormalize(n)
+		if !ok {
+			return nil, false
+		}
+		obj[k] = v
+	}
+
+	return obj, true
+}
@@ -0,0 +1,401 @@
+package extensions
+
+import (
+	"context"
+	"fmt"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	cacheUserQuery    = `query { user(id: 1) { __typename id name friends { __typename id name } } }`
+	cacheRenameQuery  = `mutation { rename(id: 2, name: "Bobby") { __typename id name } }`
+	cacheSettingQuery = `query { settings { __typename key value } }`
+	cacheAliasQuery   = `query ($size: Int!) { user(id: 1) { ...U } } fragment U on User { __typename key: id name: nickname avatar(size: $size) }`
+)
+
+type cacheServer struct {
+	requests int32
+	name     atomic.Value
+}
+
+func (s *cacheServer) count() int {
+	return int(atomic.LoadInt32(&s.requests))
+}
+
+func (s *cacheServer) transport() transport.Transport {
+	s.name.Store("Alice")
+
+	return transport.Mock{
+		cacheUserQuery: func(req transport.Request) transport.Response {
+			if err := req.Context.Err(); err != nil {
+				return transport.NewErrorResponse(err)
+			}
+			atomic.AddInt32(&s.requests, 1)
+
+			return transport.NewSingleResponse(transport.NewMockOperationResponse(map[string]interface{}{
+				"user": map[string]interface{}{
+					"__typename": "User",
+					"id":         "1",
+					"name":       s.name.Load(),
+					"friends": []interface{}{
+						map[string]interface{}{"__typename": "User", "id": "2", "name": "Bob"},
+					},
+				},
+			}, nil))
+		},
+		cacheRenameQuery: func(req transport.Request) transport.Response {
+			atomic.AddInt32(&s.requests, 1)
+
+			return transport.NewSingleResponse(transport.NewMockOperationResponse(map[string]interface{}{
+				"rename": map[string]interface{}{"__typename": "User", "id": "2", "name": "Bobby"},
+			}, nil))
+		},
+		cacheAliasQuery: func(req transport.Request) transport.Response {
+			atomic.AddInt32(&s.requests, 1)
+
+			return transport.NewSingleResponse(transport.NewMockOperationResponse(map[string]interface{}{
+				"user": map[string]interface{}{
+					"__typename": "User",
+					"key":        "1",
+					"name":       "Ally",
+					"avatar":     fmt.Sprintf("a%v", req.Variables["size"]),
+				},
+			}, nil))
+		},
+		cacheSettingQuery: func(req transport.Request) transport.Response {
+			atomic.AddInt32(&s.requests, 1)
+
+			return transport.NewSingleResponse(transport.NewMockOperationResponse(map[string]interface{}{
+				"settings": map[string]interface{}{"__typename": "Setting", "key": "theme", "value": "dark"},
+			}, nil))
+		},
+	}
+}
+
+type cacheUser struct {
+	ID      string      `json:"id"`
+	Name    string      `json:"name"`
+	Friends []cacheUser `json:"friends"`
+}
+
+// eventually fails the test if cond is still false after a second
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func cachecli(c *Cache) (*client.Client, *cacheServer) {
+	srv := &cacheServer{}
+
+	cli := &client.Client{
+		Transport: srv.transport(),
+	}
+	cli.Use(c)
+
+	return cli, srv
+}
+
+func queryUser(t *testing.T, ctx context.Context, cli *client.Client) cacheUser {
+	t.Helper()
+
+	var data struct {
+		User cacheUser `json:"user"`
+	}
+	_, err := cli.Query(ctx, "", cacheUserQuery, nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data.User
+}
+
+func TestCacheFirst(t *testing.T) {
+	ctx := context.Background()
+	c := &Cache{}
+	cli, srv := cachecli(c)
+
+	u1 := queryUser(t, ctx, cli)
+	u2 := queryUser(t, ctx, cli)
+
+	assert.Equal(t, u1, u2)
+	assert.Equal(t, "Bob", u2.Friends[0].Name)
+	assert.Equal(t, 1, srv.count())
+
+	e, ok := c.Entity("User:2")
+	if assert.True(t, ok) {
+		assert.Equal(t, `"Bob"`, string(e["name"]))
+	}
+}
+
+func TestCacheNetworkOnly(t *testing.T) {
+	ctx := WithCachePolicy(context.Background(), NetworkOnly)
+	cli, srv := cachecli(&Cache{})
+
+	queryUser(t, ctx, cli)
+	queryUser(t, ctx, cli)
+
+	assert.Equal(t, 2, srv.count())
+}
+
+func TestCacheMutationUpdatesEntities(t *testing.T) {
+	ctx := context.Background()
+	cli, srv := cachecli(&Cache{})
+
+	queryUser(t, ctx, cli)
+
+	var data map[string]interface{}
+	_, err := cli.Mutation(ctx, "", cacheRenameQuery, nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := queryUser(t, ctx, cli)
+
+	assert.Equal(t, "Bobby", u.Friends[0].Name)
+	assert.Equal(t, 2, srv.count())
+}
+
+func TestCacheAliasesAndArguments(t *testing.T) {
+	ctx := context.Background()
+	c := &Cache{}
+	cli, srv := cachecli(c)
+
+	queryAvatar := func(size int) (string, string) {
+		t.Helper()
+
+		var data struct {
+			User struct {
+				Name   string `json:"name"`
+				Avatar string `json:"avatar"`
+			} `json:"user"`
+		}
+		_, err := cli.Query(ctx, "", cacheAliasQuery, map[string]interface{}{"size": size}, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data.User.Name, data.User.Avatar
+	}
+
+	assert.Equal(t, "Alice", queryUser(t, ctx, cli).Name)
+
+	name, avatar := queryAvatar(10)
+	assert.Equal(t, "Ally", name)
+	assert.Equal(t, "a10", avatar)
+
+	_, avatar = queryAvatar(100)
+	assert.Equal(t, "a100", avatar)
+	assert.Equal(t, 3, srv.count())
+
+	// The aliased nickname and the other avatar size do not overwrite the cached fields
+	assert.Equal(t, "Alice", queryUser(t, ctx, cli).Name)
+	name, avatar = queryAvatar(10)
+	assert.Equal(t, "Ally", name)
+	assert.Equal(t, "a10", avatar)
+	assert.Equal(t, 3, srv.count())
+
+	e, ok := c.Entity("User:1")
+	if assert.True(t, ok) {
+		assert.Equal(t, `"Alice"`, string(e["name"]))
+		assert.Equal(t, `"Ally"`, string(e["nickname"]))
+		assert.Equal(t, `"a10"`, string(e[`avatar({"size":10})`]))
+		assert.Equal(t, `"a100"`, string(e[`avatar({"size":100})`]))
+	}
+}
+
+func TestCacheRequestOptions(t *testing.T) {
+	ctx := context.Background()
+	cli, srv := cachecli(&Cache{})
+
+	queryUser(t, ctx, cli)
+
+	var opts transport.RequestOptions
+	opts.SetHeader("X-Tenant", "acme")
+	queryUser(t, transport.WithRequestOptions(ctx, opts), cli)
+
+	assert.Equal(t, 2, srv.count())
+}
+
+func TestCacheKeyFields(t *testing.T) {
+	ctx := context.Background()
+	c := &Cache{
+		KeyFields: map[string][]string{
+			"Setting": {"key"},
+			"User":    {},
+		},
+	}
+	cli, srv := cachecli(c)
+
+	var data map[string]interface{}
+	_, err := cli.Query(ctx, "", cacheSettingQuery, nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := c.Entity("Setting:theme")
+	assert.True(t, ok)
+
+	queryUser(t, ctx, cli)
+
+	_, ok = c.Entity("User:1")
+	assert.False(t, ok)
+
+	// Still answered from the cache
+	queryUser(t, ctx, cli)
+	assert.Equal(t, 2, srv.count())
+}
+
+func TestCacheEvict(t *testing.T) {
+	ctx := context.Background()
+	c := &Cache{}
+	cli, srv := cachecli(c)
+
+	queryUser(t, ctx, cli)
+	c.Evict("User:2")
+	queryUser(t, ctx, cli)
+
+	assert.Equal(t, 2, srv.count())
+}
+
+func TestCacheAndNetwork(t *testing.T) {
+	ctx := context.Background()
+	cli, srv := cachecli(&Cache{})
+
+	queryUser(t, ctx, cli)
+	srv.name.Store("Alicia")
+
+	res := cli.IncrementalQuery(WithCachePolicy(ctx, CacheAndNetwork), "", cacheUserQuery, nil)
+	defer res.Close()
+
+	var names []string
+	for res.Next() {
+		var data struct {
+			User cacheUser `json:"user"`
+		}
+		_ = res.Get().UnmarshalData(&data)
+		names = append(names, data.User.Name)
+	}
+	assert.NoError(t, res.Err())
+
+	assert.Equal(t, []string{"Alice", "Alicia"}, names)
+	assert.Equal(t, "Alicia", queryUser(t, ctx, cli).Name)
+	assert.Equal(t, 2, srv.count())
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	cli, srv := cachecli(&Cache{
+		Policy: StaleWhileRevalidate,
+		MaxAge: 10 * time.Millisecond,
+	})
+
+	queryUser(t, ctx, cli)
+	srv.name.Store("Alicia")
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Stale, answered from the cache and revalidated
+	assert.Equal(t, "Alice", queryUser(t, ctx, cli).Name)
+
+	eventually(t, func() bool {
+		return srv.count() == 2
+	})
+
+	eventually(t, func() bool {
+		return queryUser(t, ctx, cli).Name == "Alicia"
+	})
+}
+
+func TestCacheMaxResults(t *testing.T) {
+	ctx := context.Background()
+	c := &Cache{MaxResults: 2}
+	cli, srv := cachecli(c)
+
+	querySettings := func() {
+		var data map[string]interface{}
+		_, err := cli.Query(ctx, "", cacheSettingQuery, nil, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queryUser(t, ctx, cli)
+	querySettings()
+	// Answered from the cache, the user result is now the most recently used
+	queryUser(t, ctx, cli)
+	assert.Equal(t, 2, srv.count())
+
+	var data map[string]interface{}
+	_, err := cli.Query(ctx, "", cacheRenameQuery, nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Query(ctx, "", cacheAliasQuery, map[string]interface{}{"size": 10}, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, srv.count())
+
+	// The settings result was evicted, with its entity
+	_, ok := c.Entity("Setting:theme")
+	assert.False(t, ok)
+	_, ok = c.Entity("User:2")
+	assert.True(t, ok)
+
+	querySettings()
+	assert.Equal(t, 5, srv.count())
+
+	// Evicts the user result, User:1 is still referenced by the alias result
+	_, ok = c.Entity("User:2")
+	assert.False(t, ok)
+	_, ok = c.Entity("User:1")
+	assert.True(t, ok)
+}
+
+// slowExtension delays the requests, so that their context can be canceled before they are sent
+type slowExtension struct{}
+
+func (e slowExtension) ExtensionName() string {
+	return "slow"
+}
+
+func (e slowExtension) AroundRequest(req transport.Request, next client.RequestHandler) transport.Response {
+	time.Sleep(20 * time.Millisecond)
+
+	return next(req)
+}
+
+func TestCacheRevalidateDetached(t *testing.T) {
+	ctx := context.Background()
+	cli, srv := cachecli(&Cache{
+		Policy: StaleWhileRevalidate,
+		MaxAge: 10 * time.Millisecond,
+	})
+	cli.Use(slowExtension{})
+
+	queryUser(t, ctx, cli)
+	srv.name.Store("Alicia")
+
+	time.Sleep(20 * time.Millisecond)
+
+	// The refresh outlives the request context
+	cctx, cancel := context.WithCancel(ctx)
+	assert.Equal(t, "Alice", queryUser(t, cctx, cli).Name)
+	cancel()
+
+	eventually(t, func() bool {
+		return srv.count() == 2
+	})
+}
@@ -0,0 +1,234 @@
+package extensions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dedup coalesces the identical queries (same operation name, query and variables) running at the same time:
+// a single request is made, its responses are fanned out to all the callers
+// The shared request is canceled once all its callers have closed their response (or their context is done)
+// Queries with headers or cookies request options are not coalesced
+type Dedup struct {
+	inflight map[string]*dedupCall
+	m        sync.Mutex
+	shared   uint64
+}
+
+var _ client.AroundRequest = (*Dedup)(nil)
+
+type dedupCall struct {
+	key    string
+	cancel context.CancelFunc
+
+	subs    []*transport.ChanResponse
+	history []transport.OperationResponse
+	done    bool
+	err     error
+	m       sync.Mutex
+}
+
+func (d *Dedup) ExtensionName() string {
+	return "dedup"
+}
+
+// Shared returns the number of requests that have been answered by another one in flight
+func (d *Dedup) Shared() uint64 {
+	return atomic.LoadUint64(&d.shared)
+}
+
+func dedupable(req transport.Request) bool {
+	if req.Operation != transport.Query {
+		return false
+	}
+
+	if hasHeaderOptions(req) {
+		return false
+	}
+
+	return len(transport.CollectUploads(req.Variables)) == 0
+}
+
+func dedupKey(req transport.Request) (string, error) {
+	// encoding/json sorts the maps keys
+	vars, err := json.Marshal(req.Variables)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(req.Query))
+
+	return fmt.Sprintf("%v:%v:%x:%s", req.Operation, req.OperationName, sum, vars), nil
+}
+
+func (d *Dedup) AroundRequest(req transport.Request, next client.RequestHandler) transport.Response {
+	if !dedupable(req) {
+		return next(req)
+	}
+
+	key, err := dedupKey(req)
+	if err != nil {
+		return next(req)
+	}
+
+	d.m.Lock()
+	if d.inflight == nil {
+		d.inflight = map[string]*dedupCall{}
+	}
+
+	call, ok := d.inflight[key]
+	if ok {
+		d.m.Unlock()
+		atomic.AddUint64(&d.shared, 1)
+
+		return d.subscribe(call, req.Context)
+	}
+
+	ctx, cancel := context.WithCancel(detachedContext{req.Context})
+	call = &dedupCall{
+		key:    key,
+		cancel: cancel,
+	}
+	d.inflight[key] = call
+	d.m.Unlock()
+
+	// Subscribe before the request is made, so that it is not canceled right away
+	res := d.subscribe(call, req.Context)
+
+	req.Context = ctx
+	go d.run(call, ctx, next(req))
+
+	return res
+}
+
+func (d *Dedup) subscribe(call *dedupCall, ctx context.Context) transport.Response {
+	call.m.Lock()
+
+	var sub *transport.ChanResponse
+	sub = transport.NewBufferedChanResponse(func() error {
+		d.unsubscribe(call, sub)
+		return ctx.Err()
+	}, transport.ResponseBuffer{Size: len(call.history) + 1})
+
+	for _, opres := range call.history {
+		sub.Send(opres)
+	}
+
+	if call.done {
+		err := call.err
+		call.m.Unlock()
+
+		// Closing unsubscribes, the lock must be released
+		if err != nil {
+			sub.CloseWithError(err)
+		} else {
+			sub.CloseCh()
+		}
+
+		return sub
+	}
+
+	call.subs = append(call.subs, sub)
+	call.m.Unlock()
+
+	return sub
+}
+
+func (d *Dedup) unsubscribe(call *dedupCall, sub *transport.ChanResponse) {
+	call.m.Lock()
+	defer call.m.Unlock()
+
+	for i, s := range call.subs {
+		if s == sub {
+			call.subs = append(call.subs[:i], call.subs[i+1:]...)
+			break
+		}
+	}
+
+	if len(call.subs) == 0 && !call.done {
+		d.remove(call)
+		call.cancel()
+	}
+}
+
+// remove takes call out of the inflight requests, the next identical queries will make a new request
+func (d *Dedup) remove(call *dedupCall) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.inflight[call.key] == call {
+		delete(d.inflight, call.key)
+	}
+}
+
+// run fans out the responses of res to the call subscribers, res is closed once ctx is canceled
+func (d *Dedup) run(call *dedupCall, ctx context.Context, res transport.Response) {
+	defer call.cancel()
+	defer res.Close()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			res.Close()
+		case <-res.Done():
+		}
+	}()
+
+	for res.Next() {
+		opres := res.Get()
+
+		call.m.Lock()
+		call.history = append(call.history, opres)
+		subs := append([]*transport.ChanResponse(nil), call.subs...)
+		call.m.Unlock()
+
+		for _, sub := range subs {
+			sub.Send(opres)
+		}
+	}
+
+	d.remove(call)
+
+	call.m.Lock()
+	call.done = true
+	call.err = res.Err()
+	subs := call.subs
+	call.subs = nil
+	call.m.Unlock()
+
+	for _, sub := range subs {
+		if call.err != nil {
+			sub.CloseWithError(call.err)
+		} else {
+			sub.CloseCh()
+		}
+	}
+}
+
+// detachedContext keeps the values of its parent, but not its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
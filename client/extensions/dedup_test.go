@@ -0,0 +1,186 @@
+package extensions
+
+import (
+	"context"
+	"errors"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const dedupQuery = `query { hello }`
+
+// dedupServer answers once release is closed, closed is closed when a response is closed before
+type dedupServer struct {
+	requests int32
+	release  chan struct{}
+	closed   chan struct{}
+}
+
+func newDedupServer() *dedupServer {
+	return &dedupServer{
+		release: make(chan struct{}),
+		closed:  make(chan struct{}, 10),
+	}
+}
+
+func (s *dedupServer) count() int {
+	return int(atomic.LoadInt32(&s.requests))
+}
+
+func (s *dedupServer) handle(req transport.Request) transport.Response {
+	atomic.AddInt32(&s.requests, 1)
+
+	done := make(chan struct{})
+	res := transport.NewChanResponse(func() error {
+		select {
+		case <-done:
+		default:
+			s.closed <- struct{}{}
+		}
+		return nil
+	})
+
+	go func() {
+		select {
+		case <-s.release:
+		case <-req.Context.Done():
+			return
+		}
+
+		close(done)
+		res.Send(transport.NewMockOperationResponse(req.Variables["name"], nil))
+		res.CloseCh()
+	}()
+
+	return res
+}
+
+func dedupcli() (*client.Client, *Dedup, *dedupServer) {
+	srv := newDedupServer()
+
+	cli := &client.Client{
+		Transport: transport.Mock{
+			dedupQuery: srv.handle,
+		},
+	}
+
+	d := &Dedup{}
+	cli.Use(d)
+
+	return cli, d, srv
+}
+
+func TestDedup(t *testing.T) {
+	cli, d, srv := dedupcli()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var data string
+			_, err := cli.Query(context.Background(), "", dedupQuery, map[string]interface{}{"name": "a"}, &data)
+			assert.NoError(t, err)
+			assert.Equal(t, "a", data)
+		}()
+	}
+
+	eventually(t, func() bool {
+		return d.Shared() == 4
+	})
+
+	close(srv.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, srv.count())
+}
+
+func TestDedupVariables(t *testing.T) {
+	cli, _, srv := dedupcli()
+	close(srv.release)
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b"} {
+		name := name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var data string
+			_, err := cli.Query(context.Background(), "", dedupQuery, map[string]interface{}{"name": name}, &data)
+			assert.NoError(t, err)
+			assert.Equal(t, name, data)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, srv.count())
+}
+
+func TestDedupMutation(t *testing.T) {
+	cli, d, srv := dedupcli()
+	close(srv.release)
+
+	var data string
+	for i := 0; i < 2; i++ {
+		_, err := cli.Mutation(context.Background(), "", dedupQuery, nil, &data)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, srv.count())
+	assert.Equal(t, uint64(0), d.Shared())
+}
+
+func TestDedupCancel(t *testing.T) {
+	cli, d, srv := dedupcli()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+
+	errs := make(chan error, 2)
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		ctx := ctx
+		go func() {
+			var data string
+			_, err := cli.Query(ctx, "", dedupQuery, nil, &data)
+			errs <- err
+		}()
+	}
+
+	eventually(t, func() bool {
+		return d.Shared() == 1
+	})
+
+	cancel1()
+	assert.True(t, errors.Is(<-errs, context.Canceled))
+
+	// Still running for the other caller
+	select {
+	case <-srv.closed:
+		t.Fatal("shared request closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel2()
+	assert.True(t, errors.Is(<-errs, context.Canceled))
+
+	select {
+	case <-srv.closed:
+	case <-time.After(time.Second):
+		t.Fatal("shared request not closed")
+	}
+
+	// A new request is made
+	close(srv.release)
+
+	var data string
+	_, err := cli.Query(context.Background(), "", dedupQuery, nil, &data)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, srv.count())
+}
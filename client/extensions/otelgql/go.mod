@@ -0,0 +1,28 @@
+module github.com/infiotinc/gqlgenc/client/extensions/otelgql
+
+replace github.com/infiotinc/gqlgenc => ../../../
+
+go 1.20
+
+require (
+	github.com/infiotinc/gqlgenc v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.8.4
+	github.com/vektah/gqlparser/v2 v2.2.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/metric v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/sdk/metric v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.4 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/klauspost/compress v1.13.6 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+	nhooyr.io/websocket v1.8.7 // indirect
+)
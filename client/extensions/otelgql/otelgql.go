@@ -0,0 +1,182 @@
+// Package otelgql is an OpenTelemetry extension for the gqlgenc client
+// It lives in its own module, so that the client does not depend on OpenTelemetry
+package otelgql
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const instrumentationName = "github.com/infiotinc/gqlgenc/client/extensions/otelgql"
+
+// Attributes of the spans and metrics
+const (
+	OperationTypeKey = attribute.Key("graphql.operation.type")
+	OperationNameKey = attribute.Key("graphql.operation.name")
+	DocumentHashKey  = attribute.Key("graphql.document.hash")
+	ErrorCountKey    = attribute.Key("graphql.errors.count")
+)
+
+// Names of the metrics
+const (
+	DurationMetric            = "graphql.client.operation.duration"
+	InFlightMetric            = "graphql.client.operations.inflight"
+	ActiveSubscriptionsMetric = "graphql.client.subscriptions.active"
+)
+
+// Tracing wraps each request in a client span, named after its operation type and name (ie: "query GetRoom"),
+// which ends once the response is done. The span is marked as failed when the response has GraphQL errors, or fails
+// The trace context is injected in the request headers (see transport.RequestOptions), and in the request
+// extensions with InjectExtensions
+// It records the operations duration, the number of queries and mutations in flight, and of active subscriptions
+type Tracing struct {
+	// TracerProvider defaults to the global one
+	TracerProvider trace.TracerProvider
+	// MeterProvider defaults to the global one
+	MeterProvider metric.MeterProvider
+	// Propagator defaults to the W3C trace context
+	Propagator propagation.TextMapPropagator
+	// InjectExtensions also injects the trace context in the request extensions, for the websocket transports,
+	// which have no per-request headers. It makes every GET request URL unique, defeating the http caches
+	InjectExtensions bool
+
+	once     sync.Once
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	inFlight metric.Int64UpDownCounter
+	active   metric.Int64UpDownCounter
+}
+
+var _ client.AroundRequest = (*Tracing)(nil)
+
+func (t *Tracing) ExtensionName() string {
+	return "otel"
+}
+
+func (t *Tracing) init() {
+	tp := t.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	t.tracer = tp.Tracer(instrumentationName)
+
+	if t.Propagator == nil {
+		t.Propagator = propagation.TraceContext{}
+	}
+
+	mp := t.MeterProvider
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	var err error
+	t.duration, err = meter.Float64Histogram(DurationMetric,
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of the GraphQL operations"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	t.inFlight, err = meter.Int64UpDownCounter(InFlightMetric,
+		metric.WithDescription("Number of GraphQL queries and mutations in flight"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	t.active, err = meter.Int64UpDownCounter(ActiveSubscriptionsMetric,
+		metric.WithDescription("Number of active GraphQL subscriptions"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+}
+
+func spanName(req transport.Request) string {
+	if req.OperationName == "" {
+		return string(req.Operation)
+	}
+
+	return fmt.Sprintf("%v %v", req.Operation, req.OperationName)
+}
+
+func (t *Tracing) AroundRequest(req transport.Request, next client.RequestHandler) transport.Response {
+	t.once.Do(t.init)
+
+	attrs := []attribute.KeyValue{
+		OperationTypeKey.String(string(req.Operation)),
+	}
+	if req.OperationName != "" {
+		attrs = append(attrs, OperationNameKey.String(req.OperationName))
+	}
+	metricAttrs := metric.WithAttributes(attrs...)
+
+	ctx, span := t.tracer.Start(req.Context, spanName(req),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(DocumentHashKey.String(fmt.Sprintf("%x", sha256.Sum256([]byte(req.Query))))),
+	)
+
+	carrier := propagation.MapCarrier{}
+	t.Propagator.Inject(ctx, carrier)
+
+	req.Context = ctx
+	req.Options = req.Options.Clone()
+	for k, v := range carrier {
+		req.Options.SetTraceHeader(k, v)
+		if t.InjectExtensions {
+			req.Extensions[k] = v
+		}
+	}
+
+	counter := t.inFlight
+	if req.Operation == transport.Subscription {
+		counter = t.active
+	}
+	counter.Add(ctx, 1, metricAttrs)
+
+	start := time.Now()
+	res := next(req)
+
+	var errCount int64
+
+	nres := transport.NewProxyResponse()
+	nres.Bind(res, func(opres transport.OperationResponse, send func()) {
+		atomic.AddInt64(&errCount, int64(len(opres.Errors)))
+		send()
+	})
+
+	go func() {
+		<-nres.Done()
+
+		count := atomic.LoadInt64(&errCount)
+		span.SetAttributes(ErrorCountKey.Int64(count))
+
+		if err := nres.Err(); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else if count > 0 {
+			span.SetStatus(codes.Error, fmt.Sprintf("%v GraphQL errors", count))
+		}
+
+		counter.Add(ctx, -1, metricAttrs)
+		t.duration.Record(ctx, time.Since(start).Seconds(), metricAttrs)
+
+		span.End()
+	}()
+
+	return nres
+}
@@ -0,0 +1,325 @@
+package otelgql
+
+import (
+	"context"
+	"errors"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/extensions"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fixture struct {
+	cli      *client.Client
+	spans    *tracetest.InMemoryExporter
+	metrics  *sdkmetric.ManualReader
+	requests chan transport.Request
+	sub      *transport.ChanResponse
+}
+
+func newFixture(opts ...func(*Tracing)) *fixture {
+	f := &fixture{
+		spans:    tracetest.NewInMemoryExporter(),
+		metrics:  sdkmetric.NewManualReader(),
+		requests: make(chan transport.Request, 10),
+		sub:      transport.NewChanResponse(nil),
+	}
+
+	f.cli = &client.Client{
+		Transport: transport.Mock{
+			"query": func(req transport.Request) transport.Response {
+				f.requests <- req
+				return transport.NewSingleResponse(transport.NewMockOperationResponse("hey", nil))
+			},
+			"errors": func(req transport.Request) transport.Response {
+				f.requests <- req
+				return transport.NewSingleResponse(transport.NewMockOperationResponse(nil, gqlerror.List{
+					{Message: "err1"},
+					{Message: "err2"},
+				}))
+			},
+			"fail": func(req transport.Request) transport.Response {
+				f.requests <- req
+				return transport.NewErrorResponse(errors.New("network down"))
+			},
+			"subscription": func(req transport.Request) transport.Response {
+				f.requests <- req
+				return f.sub
+			},
+		},
+	}
+
+	tracing := &Tracing{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(f.spans)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(f.metrics)),
+	}
+	for _, opt := range opts {
+		opt(tracing)
+	}
+	f.cli.Use(tracing)
+
+	return f
+}
+
+func (f *fixture) span(t *testing.T) tracetest.SpanStub {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		spans := f.spans.GetSpans()
+		if len(spans) > 0 {
+			return spans[0]
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("no span")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (f *fixture) metric(t *testing.T, name string) metricdata.Metrics {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := f.metrics.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+
+	t.Fatalf("metric %v not found", name)
+
+	return metricdata.Metrics{}
+}
+
+func (f *fixture) sum(t *testing.T, name string) int64 {
+	t.Helper()
+
+	var total int64
+	for _, dp := range f.metric(t, name).Data.(metricdata.Sum[int64]).DataPoints {
+		total += dp.Value
+	}
+
+	return total
+}
+
+func attr(attrs []attribute.KeyValue, key attribute.Key) attribute.Value {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+
+	return attribute.Value{}
+}
+
+func TestTracingQuery(t *testing.T) {
+	f := newFixture()
+
+	var data string
+	_, err := f.cli.Query(context.Background(), "GetHey", "query", nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span := f.span(t)
+	assert.Equal(t, "query GetHey", span.Name)
+	assert.Equal(t, "query", attr(span.Attributes, OperationTypeKey).AsString())
+	assert.Equal(t, "GetHey", attr(span.Attributes, OperationNameKey).AsString())
+	assert.Len(t, attr(span.Attributes, DocumentHashKey).AsString(), 64)
+	assert.Equal(t, int64(0), attr(span.Attributes, ErrorCountKey).AsInt64())
+	assert.Equal(t, codes.Unset, span.Status.Code)
+
+	// Trace context propagation
+	req := <-f.requests
+	traceparent := req.Options.TraceHeader.Get("traceparent")
+	assert.True(t, strings.Contains(traceparent, span.SpanContext.TraceID().String()))
+	assert.NotContains(t, req.Extensions, "traceparent")
+
+	hist := f.metric(t, DurationMetric).Data.(metricdata.Histogram[float64])
+	if assert.Len(t, hist.DataPoints, 1) {
+		assert.Equal(t, uint64(1), hist.DataPoints[0].Count)
+	}
+	assert.Equal(t, int64(0), f.sum(t, InFlightMetric))
+}
+
+func TestTracingInjectExtensions(t *testing.T) {
+	f := newFixture(func(t *Tracing) {
+		t.InjectExtensions = true
+	})
+
+	var data string
+	_, err := f.cli.Query(context.Background(), "", "query", nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-f.requests
+	assert.NotEmpty(t, req.Extensions["traceparent"])
+	assert.Equal(t, req.Options.TraceHeader.Get("traceparent"), req.Extensions["traceparent"])
+}
+
+func TestTracingHttpGet(t *testing.T) {
+	type received struct {
+		url         string
+		traceparent string
+	}
+	requests := make(chan received, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- received{url: r.URL.String(), traceparent: r.Header.Get("traceparent")}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":"hey"}`))
+	}))
+	defer srv.Close()
+
+	cli := &client.Client{
+		Transport: &transport.Http{
+			URL:    srv.URL,
+			UseGet: true,
+		},
+	}
+	cli.Use(&Tracing{
+		TracerProvider: sdktrace.NewTracerProvider(),
+	})
+
+	var data string
+	_, err := cli.Query(context.Background(), "", "query { hey }", nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The URL stays cacheable, the trace context is in the headers only
+	req := <-requests
+	assert.Equal(t, "/?query=query+%7B+hey+%7D", req.url)
+	assert.NotEmpty(t, req.traceparent)
+}
+
+func TestTracingCacheAndDedup(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	traceparents := make(chan string, 10)
+
+	cli := &client.Client{
+		Transport: transport.Mock{
+			"query { hey }": func(req transport.Request) transport.Response {
+				atomic.AddInt32(&requests, 1)
+				traceparents <- req.Options.TraceHeader.Get("traceparent")
+				<-release
+
+				return transport.NewSingleResponse(transport.NewMockOperationResponse(map[string]interface{}{"hey": "hi"}, nil))
+			},
+		},
+	}
+	cli.Use(&Tracing{
+		TracerProvider: sdktrace.NewTracerProvider(),
+	})
+	cli.Use(&extensions.Cache{})
+	dedup := &extensions.Dedup{}
+	cli.Use(dedup)
+
+	query := func(ctx context.Context) {
+		var data struct {
+			Hey string `json:"hey"`
+		}
+		_, err := cli.Query(ctx, "", "query { hey }", nil, &data)
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", data.Hey)
+	}
+
+	// The traced requests are still deduplicated
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query(context.Background())
+		}()
+	}
+
+	assert.NotEmpty(t, <-traceparents)
+	deadline := time.Now().Add(time.Second)
+	for dedup.Shared() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, uint64(1), dedup.Shared())
+
+	// And answered from the cache
+	query(context.Background())
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestTracingGraphQLErrors(t *testing.T) {
+	f := newFixture()
+
+	var data string
+	_, err := f.cli.Query(context.Background(), "", "errors", nil, &data)
+	assert.Error(t, err)
+
+	span := f.span(t)
+	assert.Equal(t, "query", span.Name)
+	assert.Equal(t, int64(2), attr(span.Attributes, ErrorCountKey).AsInt64())
+	assert.Equal(t, codes.Error, span.Status.Code)
+}
+
+func TestTracingTransportError(t *testing.T) {
+	f := newFixture()
+
+	var data string
+	_, err := f.cli.Mutation(context.Background(), "Fail", "fail", nil, &data)
+	assert.EqualError(t, err, "network down")
+
+	span := f.span(t)
+	assert.Equal(t, "mutation Fail", span.Name)
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Equal(t, "network down", span.Status.Description)
+	if assert.Len(t, span.Events, 1) {
+		assert.Equal(t, "exception", span.Events[0].Name)
+	}
+}
+
+func TestTracingSubscription(t *testing.T) {
+	f := newFixture()
+
+	res := f.cli.Subscription(context.Background(), "", "subscription", nil)
+	<-f.requests
+
+	assert.Equal(t, int64(1), f.sum(t, ActiveSubscriptionsMetric))
+	assert.Len(t, f.spans.GetSpans(), 0)
+
+	f.sub.Send(transport.NewMockOperationResponse("hey", nil))
+	assert.True(t, res.Next())
+
+	res.Close()
+
+	span := f.span(t)
+	assert.Equal(t, "subscription", span.Name)
+	assert.Equal(t, int64(0), f.sum(t, ActiveSubscriptionsMetric))
+}
@@ -0,0 +1,251 @@
+package extensions
+
+import (
+	"context"
+	"errors"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type retrySafeKey struct{}
+
+// WithRetrySafe marks the mutations requested with the returned context as safe to retry (ie: idempotent)
+func WithRetrySafe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retrySafeKey{}, true)
+}
+
+func isRetrySafe(ctx context.Context) bool {
+	safe, _ := ctx.Value(retrySafeKey{}).(bool)
+
+	return safe
+}
+
+// Retry retries the queries, and the mutations marked with WithRetrySafe, that failed with a retryable error
+// Subscriptions, and requests with uploads that cannot be replayed, are never retried
+// The wait between attempts is the longest of the Backoff and the Retry-After header, no attempt is made if it would
+// end after the request context deadline, the last failure is returned instead
+type Retry struct {
+	// MaxAttempts is the max number of attempts, including the first one. Defaults to 3
+	MaxAttempts int
+	// Backoff defaults to an ExponentialBackoff starting at 100ms, capped to 5s, with a 20% jitter
+	Backoff transport.Backoff
+	// RetryError decides if a transport error, that is not an *transport.HTTPError, is retried
+	// Defaults to retrying the network errors only (net.Error, io.ErrUnexpectedEOF), the context ones excepted
+	RetryError func(err error) bool
+	// RetryStatuses are the HTTP status codes retried, defaults to 408, 429, 502, 503 and 504
+	RetryStatuses []int
+	// RetryCodes are the GraphQL errors extension codes retried, none by default
+	RetryCodes []string
+}
+
+var _ client.AroundRequest = (*Retry)(nil)
+
+var defaultRetryStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+func (r *Retry) ExtensionName() string {
+	return "retry"
+}
+
+func (r *Retry) maxAttempts() int {
+	if r.MaxAttempts <= 0 {
+		return 3
+	}
+
+	return r.MaxAttempts
+}
+
+func (r *Retry) backoff() transport.Backoff {
+	if r.Backoff == nil {
+		return &transport.ExponentialBackoff{
+			InitialInterval: 100 * time.Millisecond,
+			MaxInterval:     5 * time.Second,
+			Jitter:          0.2,
+		}
+	}
+
+	return r.Backoff
+}
+
+func (r *Retry) retryable(req transport.Request) bool {
+	switch req.Operation {
+	case transport.Query:
+	case transport.Mutation:
+		if !isRetrySafe(req.Context) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	for _, up := range transport.CollectUploads(req.Variables) {
+		if !up.Replayable() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shouldRetry returns whether the attempt result should be retried, and the Retry-After header if any
+func (r *Retry) shouldRetry(opres *transport.OperationResponse, err error) (bool, http.Header) {
+	if err != nil {
+		var herr *transport.HTTPError
+		if errors.As(err, &herr) {
+			statuses := r.RetryStatuses
+			if statuses == nil {
+				statuses = defaultRetryStatuses
+			}
+
+			for _, status := range statuses {
+				if herr.StatusCode == status {
+					return true, herr.Header
+				}
+			}
+
+			return false, nil
+		}
+
+		if r.RetryError != nil {
+			return r.RetryError(err), nil
+		}
+
+		return isNetworkError(err), nil
+	}
+
+	if opres == nil {
+		return false, nil
+	}
+
+	for _, gqlerr := range opres.Errors {
+		code, _ := gqlerr.Extensions["code"].(string)
+		for _, rc := range r.RetryCodes {
+			if code == rc {
+				return true, opres.Header
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// isNetworkError returns whether err comes from the network, and not from the context, the decoding of the response
+// or a limit of the transport (ie: transport.ErrHttpResponseTooLarge), which would fail again
+func isNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter parses the Retry-After header, either a number of seconds or a date
+func retryAfter(header http.Header) time.Duration {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+func (r *Retry) AroundRequest(req transport.Request, next client.RequestHandler) transport.Response {
+	if !r.retryable(req) {
+		return next(req)
+	}
+
+	ctx := req.Context
+	backoff := r.backoff()
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		res := next(req)
+
+		var opres *transport.OperationResponse
+		var result transport.Response = res
+		if res.Next() {
+			first := res.Get()
+			opres = &first
+			result = &peekedResponse{Response: res, first: first}
+		}
+
+		retry, header := r.shouldRetry(opres, res.Err())
+		if !retry || attempt >= r.maxAttempts() {
+			return result
+		}
+
+		wait, ok := backoff.Next(attempt, time.Since(start))
+		if !ok {
+			return result
+		}
+
+		if ra := retryAfter(header); ra > wait {
+			wait = ra
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return result
+		}
+
+		res.Close()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return transport.NewErrorResponse(ctx.Err())
+		}
+	}
+}
+
+// peekedResponse replays the first response, already read from Response
+type peekedResponse struct {
+	transport.Response
+	first transport.OperationResponse
+
+	replayed bool
+	inner    bool
+}
+
+func (r *peekedResponse) Next() bool {
+	if !r.replayed {
+		r.replayed = true
+		return true
+	}
+
+	r.inner = true
+
+	return r.Response.Next()
+}
+
+func (r *peekedResponse) Get() transport.OperationResponse {
+	if r.inner {
+		return r.Response.Get()
+	}
+
+	return r.first
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"sync"
+	"testing"
+)
+
+// recordExtension records the calls to its hooks in calls
+type recordExtension struct {
+	name  string
+	calls *[]string
+	m     *sync.Mutex
+}
+
+func (e *recordExtension) record(hook string) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	*e.calls = append(*e.calls, fmt.Sprintf("%v:%v", e.name, hook))
+}
+
+func (e *recordExtension) ExtensionName() string {
+	return e.name
+}
+
+func (e *recordExtension) BeforeRequest(req transport.Request) (transport.Request, error) {
+	e.record("before")
+	req.Extensions[e.name] = true
+
+	return req, nil
+}
+
+func (e *recordExtension) AroundRequest(req transport.Request, next RequestHandler) transport.Response {
+	e.record("around")
+
+	return next(req)
+}
+
+func (e *recordExtension) AroundResponse(req transport.Request, opres transport.OperationResponse, next ResponseHandler) {
+	e.record("response")
+	next(opres)
+}
+
+func (e *recordExtension) OnError(req transport.Request, err error) {
+	e.record("error")
+}
+
+func recordcli(handler transport.Func) (*Client, func(name string, priority int), func() []string) {
+	var calls []string
+	var m sync.Mutex
+
+	cli := &Client{
+		Transport: transport.Mock{
+			"query": handler,
+		},
+	}
+
+	use := func(name string, priority int) {
+		cli.UseWithPriority(&recordExtension{name: name, calls: &calls, m: &m}, priority)
+	}
+
+	get := func() []string {
+		m.Lock()
+		defer m.Unlock()
+
+		return append([]string(nil), calls...)
+	}
+
+	return cli, use, get
+}
+
+func heyHandler(req transport.Request) transport.Response {
+	return transport.NewSingleResponse(transport.NewMockOperationResponse("hey", nil))
+}
+
+func TestExtensionsOrder(t *testing.T) {
+	cli, use, calls := recordcli(heyHandler)
+
+	use("a", 0)
+	use("b", 0)
+	use("c", 10)
+	use("d", -10)
+
+	var names []string
+	for _, e := range cli.Extensions() {
+		names = append(names, e.ExtensionName())
+	}
+	assert.Equal(t, []string{"c", "a", "b", "d"}, names)
+
+	var data string
+	_, err := cli.Query(context.Background(), "", "query", nil, &data)
+	assert.NoError(t, err)
+	assert.Equal(t, "hey", data)
+
+	assert.Equal(t, []string{
+		"c:before", "a:before", "b:before", "d:before",
+		"c:around", "a:around", "b:around", "d:around",
+		"d:response", "b:response", "a:response", "c:response",
+	}, calls())
+}
+
+func TestRemoveExtension(t *testing.T) {
+	cli, use, calls := recordcli(heyHandler)
+
+	use("a", 0)
+	use("b", 0)
+
+	assert.True(t, cli.RemoveExtension("a"))
+	assert.False(t, cli.RemoveExtension("a"))
+	assert.Len(t, cli.Extensions(), 1)
+
+	var data string
+	_, err := cli.Query(context.Background(), "", "query", nil, &data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"b:before", "b:around", "b:response"}, calls())
+}
+
+type failingBeforeRequest struct{}
+
+func (e failingBeforeRequest) ExtensionName() string {
+	return "fail"
+}
+
+func (e failingBeforeRequest) BeforeRequest(req transport.Request) (transport.Request, error) {
+	return req, errors.New("rejected")
+}
+
+func TestBeforeRequestError(t *testing.T) {
+	cli, use, calls := recordcli(func(req transport.Request) transport.Response {
+		t.Fatal("request sent")
+		return nil
+	})
+
+	use("a", 0)
+	cli.Use(failingBeforeRequest{})
+
+	var data string
+	_, err := cli.Query(context.Background(), "", "query", nil, &data)
+	assert.EqualError(t, err, "rejected")
+
+	assert.Equal(t, []string{"a:before", "a:error"}, calls())
+}
+
+type upperResponse struct{}
+
+func (e upperResponse) ExtensionName() string {
+	return "upper"
+}
+
+// AroundResponse drops the responses with errors, and appends a "!" to the others
+func (e upperResponse) AroundResponse(req transport.Request, opres transport.OperationResponse, next ResponseHandler) {
+	if len(opres.Errors) > 0 {
+		return
+	}
+
+	var data string
+	_ = opres.UnmarshalData(&data)
+
+	next(transport.NewMockOperationResponse(data+"!", nil))
+}
+
+func TestAroundResponse(t *testing.T) {
+	cli := &Client{
+		Transport: transport.Mock{
+			"subscription": func(req transport.Request) transport.Response {
+				res := transport.NewChanResponse(nil)
+
+				go func() {
+					res.Send(transport.NewMockOperationResponse("a", nil))
+					res.Send(transport.NewMockOperationResponse(nil, nil))
+					res.Send(transport.NewMockOperationResponse(nil, gqlerror.List{{Message: "err"}}))
+					res.Send(transport.NewMockOperationResponse("b", nil))
+					res.CloseWithError(errors.New("gone"))
+				}()
+
+				return res
+			},
+		},
+	}
+	cli.Use(upperResponse{})
+
+	res := cli.Subscription(context.Background(), "", "subscription", nil)
+	defer res.Close()
+
+	var msgs []string
+	for res.Next() {
+		var data string
+		_ = res.Get().UnmarshalData(&data)
+		msgs = append(msgs, data)
+	}
+
+	assert.Equal(t, []string{"a!", "!", "b!"}, msgs)
+	assert.EqualError(t, res.Err(), "gone")
+}
+
+func TestOnErrorClosedByCaller(t *testing.T) {
+	sub := transport.NewChanResponse(func() error {
+		return context.Canceled
+	})
+
+	cli, use, calls := recordcli(func(req transport.Request) transport.Response {
+		return sub
+	})
+	use("a", 0)
+
+	res := cli.Subscription(context.Background(), "", "query", nil)
+	res.Close()
+
+	<-res.Done()
+	assert.Equal(t, []string{"a:before", "a:around"}, calls())
+}
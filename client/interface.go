@@ -14,5 +14,25 @@ type (
 	AroundRequest interface {
 		AroundRequest(req transport.Request, next RequestHandler) transport.Response
 	}
+
+	// BeforeRequest modifies the request before it goes through the AroundRequest extensions,
+	// an error fails the request without sending it
+	BeforeRequest interface {
+		BeforeRequest(req transport.Request) (transport.Request, error)
+	}
+
+	ResponseHandler func(opres transport.OperationResponse)
+
+	// AroundResponse is called for each received response, it is passed on by calling next
+	// (possibly modified, or several times), not calling next drops it
+	AroundResponse interface {
+		AroundResponse(req transport.Request, opres transport.OperationResponse, next ResponseHandler)
+	}
+
+	// OnError is notified when a response fails, it is not notified of the GraphQL errors (see AroundResponse),
+	// nor when the response is closed by the caller
+	OnError interface {
+		OnError(req transport.Request, err error)
+	}
 )
 
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait before the next attempt
+// attempt starts at 1, elapsed is the time since the first attempt
+// Returning false means no more attempts should be made
+type Backoff interface {
+	Next(attempt int, elapsed time.Duration) (time.Duration, bool)
+}
+
+type BackoffFunc func(attempt int, elapsed time.Duration) (time.Duration, bool)
+
+func (f BackoffFunc) Next(attempt int, elapsed time.Duration) (time.Duration, bool) {
+	return f(attempt, elapsed)
+}
+
+// ConstantBackoff waits d between attempts, forever
+func ConstantBackoff(d time.Duration) Backoff {
+	return BackoffFunc(func(int, time.Duration) (time.Duration, bool) {
+		return d, true
+	})
+}
+
+// ExponentialBackoff waits InitialInterval * Multiplier^(attempt-1), capped to MaxInterval,
+// randomized by +/- Jitter percent
+type ExponentialBackoff struct {
+	// InitialInterval defaults to 500ms
+	InitialInterval time.Duration
+	// MaxInterval caps the computed interval, 0 means no cap
+	MaxInterval time.Duration
+	// Multiplier defaults to 2
+	Multiplier float64
+	// Jitter is the randomization factor, between 0 and 1
+	Jitter float64
+	// MaxAttempts stops the backoff after that many attempts, 0 means no limit
+	MaxAttempts int
+	// MaxElapsedTime stops the backoff once that much time has passed since the first attempt, 0 means no limit
+	MaxElapsedTime time.Duration
+}
+
+var _ Backoff = (*ExponentialBackoff)(nil)
+
+func (b *ExponentialBackoff) Next(attempt int, elapsed time.Duration) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt >= b.MaxAttempts {
+		return 0, false
+	}
+
+	if b.MaxElapsedTime > 0 && elapsed >= b.MaxElapsedTime {
+		return 0, false
+	}
+
+	initial := b.InitialInterval
+	if initial <= 0 {
+		initial = 500 * time.Millisecond
+	}
+
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.MaxInterval > 0 && d > float64(b.MaxInterval) {
+		d = float64(b.MaxInterval)
+	}
+
+	if b.Jitter > 0 {
+		delta := b.Jitter * d
+		d = d - delta + rand.Float64()*(2*delta)
+	}
+
+	if b.MaxElapsedTime > 0 && elapsed+time.Duration(d) > b.MaxElapsedTime {
+		d = float64(b.MaxElapsedTime - elapsed)
+	}
+
+	return time.Duration(d), true
+}
@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+)
+
+// incrementalPayload is a multipart/mixed part, it is either the initial payload, or a subsequent payload
+// in the current format (with incremental) or the previous one (with data/items and path at the top level)
+type incrementalPayload struct {
+	OperationResponse
+	Incremental []OperationResponse `json:"incremental,omitempty"`
+}
+
+// responses flattens the payload into one OperationResponse per initial or incremental result
+func (p incrementalPayload) responses() []OperationResponse {
+	if len(p.Incremental) == 0 {
+		if len(p.Data) == 0 && len(p.Errors) == 0 && len(p.Items) == 0 && len(p.Extensions) == 0 {
+			// Nothing but hasNext
+			return nil
+		}
+
+		return []OperationResponse{p.OperationResponse}
+	}
+
+	rs := make([]OperationResponse, 0, len(p.Incremental))
+	for i, inc := range p.Incremental {
+		inc.HasNext = p.HasNext || i < len(p.Incremental)-1
+		if i == 0 && len(p.Extensions) > 0 && len(inc.Extensions) == 0 {
+			inc.Extensions = p.Extensions
+		}
+		rs = append(rs, inc)
+	}
+
+	return rs
+}
+
+// newIncrementalResponse streams the parts of a multipart/mixed body as successive responses
+// Each part is limited to maxSize bytes (if > 0), the responses carry header
+func newIncrementalResponse(body io.ReadCloser, boundary string, header http.Header, maxSize int64) Response {
+	res := NewChanResponse(body.Close)
+
+	go func() {
+		defer res.CloseCh()
+
+		if boundary == "" {
+			res.CloseWithError(errors.New("multipart/mixed response without boundary"))
+			return
+		}
+
+		mr := multipart.NewReader(body, boundary)
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				_ = body.Close()
+				return
+			}
+			if err != nil {
+				select {
+				case <-res.Done():
+					// Closed by the consumer
+				default:
+					res.CloseWithError(err)
+				}
+				return
+			}
+
+			data, err := ioutil.ReadAll(limitReader(part, maxSize))
+			if err != nil {
+				select {
+				case <-res.Done():
+				default:
+					res.CloseWithError(err)
+				}
+				return
+			}
+
+			if len(data) == 0 {
+				// Heartbeat
+				continue
+			}
+
+			var payload incrementalPayload
+			if err := json.Unmarshal(data, &payload); err != nil {
+				res.CloseWithError(err)
+				return
+			}
+
+			for _, opres := range payload.responses() {
+				opres.Header = header
+				res.Send(opres)
+			}
+
+			if !payload.HasNext {
+				_ = body.Close()
+				return
+			}
+		}
+	}()
+
+	return res
+}
@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Content encodings supported by Http
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+)
+
+// ErrHttpResponseTooLarge is returned when a response is larger than Http.MaxResponseSize
+var ErrHttpResponseTooLarge = errors.New("http response too large")
+
+func compress(encoding string, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	case EncodingZstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %v", encoding)
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type decompressedBody struct {
+	io.Reader
+	close func()
+	body  io.ReadCloser
+}
+
+func (b *decompressedBody) Close() error {
+	if b.close != nil {
+		b.close()
+	}
+
+	return b.body.Close()
+}
+
+// decompressBody replaces res.Body with its decompressed content, according to the Content-Encoding
+func decompressBody(res *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+
+	var body *decompressedBody
+	switch encoding {
+	case "", "identity":
+		return nil
+	case EncodingGzip:
+		r, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return err
+		}
+		body = &decompressedBody{Reader: r}
+	case EncodingBrotli:
+		body = &decompressedBody{Reader: brotli.NewReader(res.Body)}
+	case EncodingZstd:
+		r, err := zstd.NewReader(res.Body)
+		if err != nil {
+			return err
+		}
+		body = &decompressedBody{Reader: r, close: r.Close}
+	default:
+		return fmt.Errorf("unsupported content encoding: %v", encoding)
+	}
+
+	body.body = res.Body
+	res.Body = body
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+
+	return nil
+}
+
+// maxSizeReader fails with ErrHttpResponseTooLarge once more than max bytes are read
+type maxSizeReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func limitReader(r io.Reader, max int64) io.Reader {
+	if max <= 0 {
+		return r
+	}
+
+	return &maxSizeReader{r: r, max: max, n: max}
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		// Is there anything left ?
+		var b [1]byte
+		n, err := r.r.Read(b[:])
+		if n > 0 {
+			return 0, fmt.Errorf("%w: more than %v bytes", ErrHttpResponseTooLarge, r.max)
+		}
+
+		return 0, err
+	}
+
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+
+	return n, err
+}
+
+// headBuffer keeps the first max bytes written to it
+type headBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if n := b.max - len(b.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		b.buf = append(b.buf, p[:n]...)
+	}
+
+	return len(p), nil
+}
+
+// fill reads r until the buffer is full
+func (b *headBuffer) fill(r io.Reader) {
+	if n := b.max - len(b.buf); n > 0 {
+		_, _ = io.CopyN(b, r, int64(n))
+	}
+}
+
+func (b *headBuffer) Bytes() []byte {
+	return b.buf
+}
@@ -0,0 +1,218 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// decodeOperationResponse reads a GraphQL response object from r as it streams in
+// The data and items values are copied from the stream into their own buffer, the body is never held as a whole: the
+// memory used is about the size of the data, instead of twice with a json.Decoder (its buffer, and the copy to the
+// json.RawMessage). The other members are small, they are collected then unmarshalled
+func decodeOperationResponse(r io.Reader) (*OperationResponse, error) {
+	s := &responseScanner{r: bufio.NewReader(r)}
+
+	c, err := s.next()
+	if err != nil {
+		return nil, err
+	}
+	if c != '{' {
+		return nil, s.syntaxError(c, "looking for beginning of object")
+	}
+
+	var opres OperationResponse
+	// Collects the members but data and items as an object
+	rest := bytes.NewBufferString("{")
+
+	c, err = s.next()
+	if err != nil {
+		return nil, err
+	}
+
+	for c != '}' {
+		if c != '"' {
+			return nil, s.syntaxError(c, "looking for beginning of object key string")
+		}
+
+		var kb bytes.Buffer
+		kb.WriteByte(c)
+		if err := s.readString(&kb); err != nil {
+			return nil, err
+		}
+
+		var key string
+		if err := json.Unmarshal(kb.Bytes(), &key); err != nil {
+			return nil, err
+		}
+
+		c, err = s.next()
+		if err != nil {
+			return nil, err
+		}
+		if c != ':' {
+			return nil, s.syntaxError(c, "after object key")
+		}
+
+		c, err = s.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "data", "items":
+			var vb bytes.Buffer
+			if err := s.readValue(&vb, c); err != nil {
+				return nil, err
+			}
+			if !json.Valid(vb.Bytes()) {
+				return nil, fmt.Errorf("invalid %v value in GraphQL response", key)
+			}
+
+			if key == "data" {
+				opres.Data = vb.Bytes()
+			} else {
+				opres.Items = vb.Bytes()
+			}
+		default:
+			if rest.Len() > 1 {
+				rest.WriteByte(',')
+			}
+			rest.Write(kb.Bytes())
+			rest.WriteByte(':')
+			if err := s.readValue(rest, c); err != nil {
+				return nil, err
+			}
+		}
+
+		c, err = s.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch c {
+		case ',':
+			c, err = s.next()
+			if err != nil {
+				return nil, err
+			}
+		case '}':
+		default:
+			return nil, s.syntaxError(c, "after object key:value pair")
+		}
+	}
+	rest.WriteByte('}')
+
+	data, items := opres.Data, opres.Items
+	if err := json.Unmarshal(rest.Bytes(), &opres); err != nil {
+		return nil, err
+	}
+	opres.Data, opres.Items = data, items
+
+	return &opres, nil
+}
+
+// responseScanner reads the tokens of a JSON value, the values are checked by json.Valid or json.Unmarshal once read
+type responseScanner struct {
+	r *bufio.Reader
+}
+
+func (s *responseScanner) syntaxError(c byte, context string) error {
+	return fmt.Errorf("invalid character %q %v in GraphQL response", c, context)
+}
+
+// read returns the next byte, the stream ending is io.ErrUnexpectedEOF
+func (s *responseScanner) read() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return c, err
+}
+
+// next returns the next byte that is not a whitespace
+func (s *responseScanner) next() (byte, error) {
+	for {
+		c, err := s.read()
+		if err != nil {
+			return 0, err
+		}
+
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+// readString copies the string, whose opening quote has been read, up to its closing quote
+func (s *responseScanner) readString(buf *bytes.Buffer) error {
+	for {
+		c, err := s.read()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+
+		switch c {
+		case '"':
+			return nil
+		case '\\':
+			c, err := s.read()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// readValue copies the value starting with first
+func (s *responseScanner) readValue(buf *bytes.Buffer, first byte) error {
+	buf.WriteByte(first)
+
+	switch first {
+	case '"':
+		return s.readString(buf)
+	case '{', '[':
+		for depth := 1; depth > 0; {
+			c, err := s.read()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(c)
+
+			switch c {
+			case '"':
+				if err := s.readString(buf); err != nil {
+					return err
+				}
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+
+		return nil
+	}
+
+	// Number, true, false or null, up to the next delimiter
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch c {
+		case ',', '}', ']', ' ', '\t', '\r', '\n':
+			return s.r.UnreadByte()
+		}
+		buf.WriteByte(c)
+	}
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeOperationResponse(t *testing.T) {
+	bodies := []string{
+		`{"data":{"room":{"name":"a \"}]\\ room","tags":["x",1,2.5e3,true,false,null]}}}`,
+		` { "data" : null , "errors" : [ { "message" : "err" , "path" : [ "room" , 0 ] } ] } `,
+		`{"errors":[{"message":"err","extensions":{"code":"BAD"}}],"extensions":{"cost":12}}`,
+		`{"path":["rooms",1],"label":"l","items":[{"id":"1"}],"hasNext":true}`,
+		`{"data":"été","unknown":{"a":[1,{"b":2}]}}`,
+		`{}`,
+	}
+
+	for _, body := range bodies {
+		var expected OperationResponse
+		if err := json.Unmarshal([]byte(body), &expected); err != nil {
+			t.Fatal(err)
+		}
+
+		opres, err := decodeOperationResponse(strings.NewReader(body))
+		if assert.NoError(t, err, body) {
+			assert.Equal(t, expected, *opres, body)
+		}
+	}
+}
+
+func TestDecodeOperationResponseInvalid(t *testing.T) {
+	bodies := []string{
+		``,
+		`[]`,
+		`{"data":{"room":1]}`,
+		`{"data":{"room":1}`,
+		`{"data" {}}`,
+		`{"data":{} "errors":[]}`,
+		`{data:{}}`,
+		`{"errors":[{"message":tru}]}`,
+		`{"data":"unterminated`,
+	}
+
+	for _, body := range bodies {
+		_, err := decodeOperationResponse(strings.NewReader(body))
+		assert.Error(t, err, body)
+	}
+
+	_, err := decodeOperationResponse(strings.NewReader(`{"data":{"room":`))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
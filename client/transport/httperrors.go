@@ -0,0 +1,34 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// httpErrorBodyLimit is the max number of bytes of the body kept in HTTPError
+const httpErrorBodyLimit = 1000
+
+// HTTPError is returned when the server responds with a non 2xx status code without a GraphQL response,
+// use errors.As to inspect it
+type HTTPError struct {
+	StatusCode int
+	Header     http.Header
+	// Body is truncated to 1000 bytes
+	Body []byte
+}
+
+func newHTTPError(res *http.Response, body []byte) *HTTPError {
+	if len(body) > httpErrorBodyLimit {
+		body = body[:httpErrorBodyLimit]
+	}
+
+	return &HTTPError{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http error %v: %s", e.StatusCode, e.Body)
+}
@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LogLevel is the level of a log entry, its values match the log/slog ones
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = -4
+	LogLevelInfo  LogLevel = 0
+	LogLevelWarn  LogLevel = 4
+	LogLevelError LogLevel = 8
+)
+
+func (l LogLevel) String() string {
+	switch {
+	case l < LogLevelInfo:
+		return "DEBUG"
+	case l < LogLevelWarn:
+		return "INFO"
+	case l < LogLevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Logger receives structured log entries, args are alternating keys and values, as with log/slog
+// A *slog.Logger can be plugged with:
+//
+//	transport.LoggerFunc(func(ctx context.Context, level transport.LogLevel, msg string, args ...interface{}) {
+//	    logger.Log(ctx, slog.Level(level), msg, args...)
+//	})
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, args ...interface{})
+}
+
+type LoggerFunc func(ctx context.Context, level LogLevel, msg string, args ...interface{})
+
+func (f LoggerFunc) Log(ctx context.Context, level LogLevel, msg string, args ...interface{}) {
+	f(ctx, level, msg, args...)
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	level LogLevel
+}
+
+// NewStdLogger logs the entries of level and above to l, as: LEVEL msg key=value...
+func NewStdLogger(l *log.Logger, level LogLevel) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+func (s *stdLogger) Log(_ context.Context, level LogLevel, msg string, args ...interface{}) {
+	if level < s.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+
+	for i := 0; i < len(args); i += 2 {
+		b.WriteString(" ")
+		if i+1 == len(args) {
+			fmt.Fprintf(&b, "!BADKEY=%v", args[i])
+			break
+		}
+
+		v := args[i+1]
+		if jb, err := json.Marshal(v); err == nil && !isScalar(v) {
+			v = string(jb)
+		}
+		fmt.Fprintf(&b, "%v=%v", args[i], v)
+	}
+
+	s.l.Println(b.String())
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, error, fmt.Stringer, bool, int, int64, uint64, float64:
+		return true
+	}
+
+	return false
+}
+
+// envLogger returns the stdout debug logger when GQLGENC_WS_LOG is set, for compatibility
+// Deprecated: set the Logger of the transport instead
+func envLogger() Logger {
+	if on, _ := strconv.ParseBool(os.Getenv("GQLGENC_WS_LOG")); on {
+		return NewStdLogger(log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds), LogLevelDebug)
+	}
+
+	return nil
+}
+
+// Redacted replaces the redacted values in the logs
+const Redacted = "[REDACTED]"
+
+// alwaysRedactedHeaders are redacted whatever the Redaction
+var alwaysRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// Redaction configures the values hidden from the logs
+type Redaction struct {
+	// Variables are the paths of the variables to redact, with the keys separated by dots (ie: input.password),
+	// a path going through a list applies to all its items
+	Variables []string
+	// Headers are redacted in addition to Authorization, Proxy-Authorization, Cookie and Set-Cookie
+	Headers []string
+}
+
+// RedactVariables returns a copy of variables, with the Variables paths redacted
+func (r Redaction) RedactVariables(variables map[string]interface{}) interface{} {
+	if len(variables) == 0 {
+		return variables
+	}
+
+	// Normalizes the variables (which can be structs) to maps
+	b, err := json.Marshal(variables)
+	if err != nil {
+		return Redacted
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return Redacted
+	}
+
+	for _, path := range r.Variables {
+		redactPath(v, strings.Split(path, "."))
+	}
+
+	return v
+}
+
+func redactPath(v interface{}, path []string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if _, ok := v[path[0]]; !ok {
+			return
+		}
+
+		if len(path) == 1 {
+			v[path[0]] = Redacted
+			return
+		}
+
+		redactPath(v[path[0]], path[1:])
+	case []interface{}:
+		for _, item := range v {
+			redactPath(item, path)
+		}
+	}
+}
+
+// logURL returns rawURL without its query string and user info, which can hold credentials (ie: a token)
+func logURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Redacted
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return u.String()
+}
+
+// RedactHeader returns a copy of header, with the sensitive headers redacted
+func (r Redaction) RedactHeader(header http.Header) http.Header {
+	h := header.Clone()
+
+	for _, names := range [][]string{alwaysRedactedHeaders, r.Headers} {
+		for _, name := range names {
+			if _, ok := h[http.CanonicalHeaderKey(name)]; ok {
+				h.Set(name, Redacted)
+			}
+		}
+	}
+
+	return h
+}
@@ -1,13 +1,61 @@
 package transport
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the sender until the consumer catches up
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered response to make room
+	OverflowDropOldest
+	// OverflowDropNewest discards the response being sent
+	OverflowDropNewest
+	// OverflowClose closes the response with ErrResponseOverflow
+	OverflowClose
+)
+
+var ErrResponseOverflow = errors.New("response buffer overflow")
+
+// ResponseBuffer configures how a ChanResponse buffers responses its consumer has not read yet
+type ResponseBuffer struct {
+	// Size is the number of responses buffered, 0 means unbuffered
+	// The policies but OverflowBlock need room to drop into, Size is at least 1 with them
+	Size int
+	// Overflow is applied when the buffer is full, defaults to OverflowBlock
+	Overflow OverflowPolicy
+}
+
+type responseBufferKey struct{}
+
+// WithResponseBuffer overrides the ResponseBuffer of the operations requested with the returned context
+func WithResponseBuffer(ctx context.Context, buf ResponseBuffer) context.Context {
+	return context.WithValue(ctx, responseBufferKey{}, buf)
+}
+
+func responseBufferFromContext(ctx context.Context, def ResponseBuffer) ResponseBuffer {
+	if ctx == nil {
+		return def
+	}
+
+	if buf, ok := ctx.Value(responseBufferKey{}).(ResponseBuffer); ok {
+		return buf
+	}
+
+	return def
+}
 
 type ChanResponse struct {
 	responseError
 
-	ch     chan OperationResponse
-	close  func() error
-	closed bool
+	ch       chan OperationResponse
+	overflow OverflowPolicy
+	close    func() error
+	closed   bool
 
 	cor OperationResponse
 	m   sync.Mutex
@@ -15,21 +63,42 @@ type ChanResponse struct {
 }
 
 func NewChanResponse(onClose func() error) *ChanResponse {
+	return NewBufferedChanResponse(onClose, ResponseBuffer{})
+}
+
+func NewBufferedChanResponse(onClose func() error, buf ResponseBuffer) *ChanResponse {
+	if buf.Overflow != OverflowBlock && buf.Size < 1 {
+		// Unbuffered, every send without a waiting consumer would overflow
+		buf.Size = 1
+	}
+
 	return &ChanResponse{
-		ch:    make(chan OperationResponse),
-		dc:    make(chan struct{}),
-		close: onClose,
+		ch:       make(chan OperationResponse, buf.Size),
+		overflow: buf.Overflow,
+		dc:       make(chan struct{}),
+		close:    onClose,
 	}
 }
 
 func (r *ChanResponse) Next() bool {
-	if r.err != nil {
+	if r.Err() != nil {
 		return false
 	}
 
-	or, ok := <-r.ch
-	r.cor = or
-	return ok
+	select {
+	case or := <-r.ch:
+		r.cor = or
+		return true
+	case <-r.dc:
+		// Drain what has been buffered before closing
+		select {
+		case or := <-r.ch:
+			r.cor = or
+			return true
+		default:
+			return false
+		}
+	}
 }
 
 func (r *ChanResponse) Get() OperationResponse {
@@ -38,7 +107,9 @@ func (r *ChanResponse) Get() OperationResponse {
 
 func (r *ChanResponse) Close() {
 	if r.close != nil {
-		r.err = r.close()
+		if err := r.close(); err != nil {
+			r.responseError.CloseWithError(err)
+		}
 	}
 	r.CloseCh()
 }
@@ -60,7 +131,6 @@ func (r *ChanResponse) CloseCh() {
 		return
 	}
 
-	close(r.ch)
 	close(r.dc)
 	r.closed = true
 }
@@ -69,12 +139,47 @@ func (r *ChanResponse) Done() <-chan struct{} {
 	return r.dc
 }
 
+// Send delivers op to the consumer, applying the overflow policy when the buffer is full
 func (r *ChanResponse) Send(op OperationResponse) {
-	r.m.Lock()
-	defer r.m.Unlock()
-
 	select {
-	case r.ch <- op:
-	case <-r.Done():
+	case <-r.dc:
+		return
+	default:
+	}
+
+	switch r.overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case r.ch <- op:
+				return
+			case <-r.dc:
+				return
+			default:
+				// Full, discard the oldest
+				select {
+				case <-r.ch:
+				default:
+				}
+			}
+		}
+	case OverflowDropNewest:
+		select {
+		case r.ch <- op:
+		case <-r.dc:
+		default:
+		}
+	case OverflowClose:
+		select {
+		case r.ch <- op:
+		case <-r.dc:
+		default:
+			r.CloseWithError(ErrResponseOverflow)
+		}
+	default:
+		select {
+		case r.ch <- op:
+		case <-r.dc:
+		}
 	}
 }
@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func sendN(res *ChanResponse, n int) {
+	for i := 0; i < n; i++ {
+		res.Send(NewMockOperationResponse(fmt.Sprintf("msg%v", i), nil))
+	}
+}
+
+func collect(t *testing.T, res Response) []string {
+	msgs := make([]string, 0)
+	for res.Next() {
+		var data string
+		err := res.Get().UnmarshalData(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, data)
+	}
+
+	return msgs
+}
+
+func TestChanResponseBlock(t *testing.T) {
+	res := NewBufferedChanResponse(nil, ResponseBuffer{Size: 1})
+
+	sent := make(chan struct{})
+	go func() {
+		sendN(res, 3)
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("send should block until consumed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.True(t, res.Next())
+	assert.True(t, res.Next())
+	<-sent
+	res.CloseCh()
+
+	assert.Equal(t, []string{"msg2"}, collect(t, res))
+	assert.NoError(t, res.Err())
+}
+
+func TestChanResponseDropOldest(t *testing.T) {
+	res := NewBufferedChanResponse(nil, ResponseBuffer{Size: 2, Overflow: OverflowDropOldest})
+
+	sendN(res, 5)
+	res.CloseCh()
+
+	assert.Equal(t, []string{"msg3", "msg4"}, collect(t, res))
+	assert.NoError(t, res.Err())
+}
+
+func TestChanResponseDropNewest(t *testing.T) {
+	res := NewBufferedChanResponse(nil, ResponseBuffer{Size: 2, Overflow: OverflowDropNewest})
+
+	sendN(res, 5)
+	res.CloseCh()
+
+	assert.Equal(t, []string{"msg0", "msg1"}, collect(t, res))
+	assert.NoError(t, res.Err())
+}
+
+func TestChanResponseOverflowClose(t *testing.T) {
+	closed := false
+	res := NewBufferedChanResponse(func() error {
+		closed = true
+		return nil
+	}, ResponseBuffer{Size: 2, Overflow: OverflowClose})
+
+	sendN(res, 3)
+
+	assert.Empty(t, collect(t, res))
+	assert.Equal(t, ErrResponseOverflow, res.Err())
+	assert.True(t, closed)
+}
+
+func TestChanResponseOverflowUnbuffered(t *testing.T) {
+	// Size is raised to 1, the sends without a consumer don't spin nor close the response
+	res := NewBufferedChanResponse(nil, ResponseBuffer{Overflow: OverflowDropOldest})
+
+	sent := make(chan struct{})
+	go func() {
+		sendN(res, 3)
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send blocked")
+	}
+	res.CloseCh()
+
+	assert.Equal(t, []string{"msg2"}, collect(t, res))
+
+	res = NewBufferedChanResponse(nil, ResponseBuffer{Overflow: OverflowClose})
+
+	sendN(res, 1)
+	res.CloseCh()
+
+	assert.Equal(t, []string{"msg0"}, collect(t, res))
+	assert.NoError(t, res.Err())
+}
+
+func TestChanResponseCloseWhileSending(t *testing.T) {
+	res := NewChanResponse(nil)
+
+	sent := make(chan struct{})
+	go func() {
+		sendN(res, 1)
+		close(sent)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	res.CloseCh()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send should be released by close")
+	}
+}
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// EventStreamContentType is the Server-Sent Events media type
+const EventStreamContentType = "text/event-stream"
+
+type sseEvent struct {
+	Event string
+	Data  []byte
+}
+
+// sseReader reads Server-Sent Events, see https://html.spec.whatwg.org/multipage/server-sent-events.html
+type sseReader struct {
+	r *bufio.Reader
+}
+
+func newSseReader(r io.Reader) *sseReader {
+	return &sseReader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Next returns the next event, comments (ie: keep alives) and events without data are skipped
+func (r *sseReader) Next() (sseEvent, error) {
+	var ev sseEvent
+	var data bytes.Buffer
+	var hasData bool
+
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				// Incomplete event, dropped as per the spec
+				err = io.ErrUnexpectedEOF
+			}
+			return sseEvent{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			// Dispatch
+			if !hasData && ev.Event == "" {
+				continue
+			}
+
+			ev.Data = data.Bytes()
+			if ev.Event == "" {
+				ev.Event = "message"
+			}
+
+			return ev, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			// Comment
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		}
+	}
+}
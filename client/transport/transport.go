@@ -3,7 +3,10 @@ package transport
 import (
 	"context"
 	"encoding/json"
+	"github.com/vektah/gqlparser/v2/ast"
 	"github.com/vektah/gqlparser/v2/gqlerror"
+	"net/http"
+	"time"
 )
 
 type Operation string
@@ -34,6 +37,22 @@ type OperationResponse struct {
 	Data       json.RawMessage `json:"data,omitempty"`
 	Errors     gqlerror.List   `json:"errors,omitempty"`
 	Extensions RawExtensions   `json:"extensions,omitempty"`
+
+	// Incremental delivery (@defer, @stream), see IsIncremental
+	// Path is where Data (deferred fragment) or Items (streamed list items) go in the initial response
+	Path  ast.Path        `json:"path,omitempty"`
+	Label string          `json:"label,omitempty"`
+	Items json.RawMessage `json:"items,omitempty"`
+	// HasNext is true when more payloads are to be expected
+	HasNext bool `json:"hasNext,omitempty"`
+
+	// Header holds the HTTP response headers, for the transports over HTTP (Http, Sse)
+	Header http.Header `json:"-"`
+}
+
+// IsIncremental returns true if the response is a subsequent payload of an incremental delivery
+func (r OperationResponse) IsIncremental() bool {
+	return r.Path != nil
 }
 
 func (r OperationResponse) UnmarshalData(t interface{}) error {
@@ -59,6 +78,112 @@ func (es RawExtensions) Unmarshal(name string, t interface{}) error {
 	return json.Unmarshal(ex, t)
 }
 
+// RequestOptions are the transport options of a single request
+// Http applies all of them, Sse applies Header and Cookies, Ws ignores them
+type RequestOptions struct {
+	// Header is set on the HTTP request, it replaces the values of the same keys set by the transport
+	Header  http.Header
+	Cookies []*http.Cookie
+	// TraceHeader carries the trace context (ie: traceparent), set on the HTTP request before Header
+	// Unlike Header, it does not change the response: the requests with a TraceHeader only are still cached,
+	// deduplicated and batched, a batch is sent with the TraceHeader of its first request
+	TraceHeader http.Header
+	// Timeout bounds the request, including reading the response. 0 means no timeout
+	Timeout time.Duration
+}
+
+// SetHeader sets the header key to value
+func (o *RequestOptions) SetHeader(key, value string) {
+	if o.Header == nil {
+		o.Header = http.Header{}
+	}
+
+	o.Header.Set(key, value)
+}
+
+// SetTraceHeader sets the trace header key to value
+func (o *RequestOptions) SetTraceHeader(key, value string) {
+	if o.TraceHeader == nil {
+		o.TraceHeader = http.Header{}
+	}
+
+	o.TraceHeader.Set(key, value)
+}
+
+// AddCookie adds a cookie to the request
+func (o *RequestOptions) AddCookie(c *http.Cookie) {
+	o.Cookies = append(o.Cookies, c)
+}
+
+// Clone returns a copy of the options that can be modified independently
+func (o RequestOptions) Clone() RequestOptions {
+	return RequestOptions{
+		Header:      o.Header.Clone(),
+		Cookies:     append([]*http.Cookie(nil), o.Cookies...),
+		TraceHeader: o.TraceHeader.Clone(),
+		Timeout:     o.Timeout,
+	}
+}
+
+// apply sets the headers and adds the cookies to req
+func (o RequestOptions) apply(req *http.Request) {
+	for _, h := range []http.Header{o.TraceHeader, o.Header} {
+		for k, vs := range h {
+			req.Header.Del(k)
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+
+	for _, c := range o.Cookies {
+		req.AddCookie(c)
+	}
+}
+
+type requestOptionsKey struct{}
+
+// WithRequestOptions sets the RequestOptions of the operations requested with the returned context,
+// Request.Options take precedence over them
+func WithRequestOptions(ctx context.Context, opts RequestOptions) context.Context {
+	return context.WithValue(ctx, requestOptionsKey{}, opts)
+}
+
+// RequestOptionsFromContext returns the RequestOptions set with WithRequestOptions
+func RequestOptionsFromContext(ctx context.Context) (RequestOptions, bool) {
+	opts, ok := ctx.Value(requestOptionsKey{}).(RequestOptions)
+
+	return opts, ok
+}
+
+// requestOptions merges the options from the context with the ones of the request
+func requestOptions(req Request) RequestOptions {
+	var opts RequestOptions
+	if req.Context != nil {
+		opts, _ = RequestOptionsFromContext(req.Context)
+	}
+	opts = opts.Clone()
+
+	for k, vs := range req.Options.Header {
+		if opts.Header == nil {
+			opts.Header = http.Header{}
+		}
+		opts.Header[k] = vs
+	}
+	for k, vs := range req.Options.TraceHeader {
+		if opts.TraceHeader == nil {
+			opts.TraceHeader = http.Header{}
+		}
+		opts.TraceHeader[k] = vs
+	}
+	opts.Cookies = append(opts.Cookies, req.Options.Cookies...)
+	if req.Options.Timeout > 0 {
+		opts.Timeout = req.Options.Timeout
+	}
+
+	return opts
+}
+
 type Request struct {
 	Context   context.Context
 	Operation Operation
@@ -67,6 +192,9 @@ type Request struct {
 	Query         string
 	Variables     map[string]interface{}
 	Extensions    map[string]interface{}
+
+	// Options are the per-request transport options
+	Options RequestOptions
 }
 
 type Transport interface {
@@ -0,0 +1,352 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// batchAccept does not include multipart/mixed, incremental delivery is not supported in batches
+const batchAccept = GraphQLResponseContentType + ", " + JSONContentType + ";q=0.9"
+
+// errBatchRejected is returned when the server does not support batches
+var errBatchRejected = errors.New("batch rejected")
+
+// errBatchRefused is returned when the server refuses a batch, because of one of its requests or its size
+var errBatchRefused = errors.New("batch refused")
+
+// Batch coalesces the queries and mutations requested within Window into one HTTP call, sent as a JSON array of
+// operations, the array of results is fanned out to the requests responses
+// Subscriptions, uploads and requests with headers or cookies options are sent individually through Http, a batch is
+// sent with the TraceHeader of its first request
+// When the server does not support batches, its requests are sent individually, as are all the subsequent requests
+// When it refuses a batch only (ie: a malformed query), its requests are sent individually
+type Batch struct {
+	// Http sends the batches, and the requests that cannot be batched
+	Http *Http
+	// Window is how long a batch collects requests after its first one. Defaults to 10ms
+	Window time.Duration
+	// MaxBatchSize sends the batch as soon as it holds that many requests. 0 means no limit
+	MaxBatchSize int
+
+	pending     *batch
+	m           sync.Mutex
+	unsupported int32
+}
+
+type batchEntry struct {
+	req Request
+	res *ChanResponse
+}
+
+type batch struct {
+	entries []*batchEntry
+	timer   *time.Timer
+}
+
+func (b *Batch) window() time.Duration {
+	if b.Window <= 0 {
+		return 10 * time.Millisecond
+	}
+
+	return b.Window
+}
+
+// Unsupported returns true once the server has shown it does not support batches (404, 405, 415, or a response
+// that is not an array). Batches refused with a 413, or with a 400 or 422 array of results, are sent individually,
+// batching goes on
+func (b *Batch) Unsupported() bool {
+	return atomic.LoadInt32(&b.unsupported) == 1
+}
+
+func (b *Batch) batchable(req Request) bool {
+	if b.Unsupported() || req.Operation == Subscription {
+		return false
+	}
+
+	opts := requestOptions(req)
+	if len(opts.Header) > 0 || len(opts.Cookies) > 0 {
+		return false
+	}
+
+	return len(b.Http.collectUploads("variables", req.Variables)) == 0
+}
+
+func (b *Batch) Request(req Request) Response {
+	if !b.batchable(req) {
+		return b.Http.Request(req)
+	}
+
+	ctx := req.Context
+	cancel := func() {}
+	if timeout := requestOptions(req).Timeout; timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	e := &batchEntry{req: req}
+	e.res = NewBufferedChanResponse(func() error {
+		// Closed because of the context, or by the consumer
+		err := ctx.Err()
+		cancel()
+		b.remove(e)
+		return err
+	}, ResponseBuffer{Size: 1})
+
+	b.add(e)
+
+	go func() {
+		defer cancel()
+
+		select {
+		case <-ctx.Done():
+			e.res.CloseWithError(ctx.Err())
+		case <-e.res.Done():
+		}
+	}()
+
+	return e.res
+}
+
+func (b *Batch) add(e *batchEntry) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.pending == nil {
+		bt := &batch{}
+		bt.timer = time.AfterFunc(b.window(), func() {
+			b.flush(bt)
+		})
+		b.pending = bt
+	}
+
+	b.pending.entries = append(b.pending.entries, e)
+
+	if b.MaxBatchSize > 0 && len(b.pending.entries) >= b.MaxBatchSize {
+		bt := b.pending
+		b.pending = nil
+		bt.timer.Stop()
+
+		go b.send(bt.entries)
+	}
+}
+
+// remove takes e out of the pending batch, if it has not been sent yet
+func (b *Batch) remove(e *batchEntry) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.pending == nil {
+		return
+	}
+
+	for i, pe := range b.pending.entries {
+		if pe == e {
+			b.pending.entries = append(b.pending.entries[:i], b.pending.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *Batch) flush(bt *batch) {
+	b.m.Lock()
+	if b.pending != bt {
+		// Already sent
+		b.m.Unlock()
+		return
+	}
+	b.pending = nil
+	entries := bt.entries
+	b.m.Unlock()
+
+	b.send(entries)
+}
+
+func (b *Batch) send(entries []*batchEntry) {
+	switch len(entries) {
+	case 0:
+		return
+	case 1:
+		b.forward(entries[0])
+		return
+	}
+
+	// The batch request is canceled once all its requests are
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		for _, e := range entries {
+			select {
+			case <-e.res.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+
+	results, header, err := b.sendBatch(ctx, entries)
+	if err == errBatchRejected || err == errBatchRefused {
+		if err == errBatchRejected {
+			atomic.StoreInt32(&b.unsupported, 1)
+		}
+
+		// The requests get their own response (or error)
+		for _, e := range entries {
+			go b.forward(e)
+		}
+		return
+	}
+
+	for i, e := range entries {
+		if err != nil {
+			e.res.CloseWithError(err)
+			continue
+		}
+
+		opres := results[i]
+		opres.Header = header
+		e.res.Send(opres)
+		e.res.CloseCh()
+	}
+}
+
+// forward sends e individually
+func (b *Batch) forward(e *batchEntry) {
+	select {
+	case <-e.res.Done():
+		return
+	default:
+	}
+
+	res := b.Http.Request(e.req)
+	defer res.Close()
+
+	go func() {
+		select {
+		case <-e.res.Done():
+			res.Close()
+		case <-res.Done():
+		}
+	}()
+
+	for res.Next() {
+		e.res.Send(res.Get())
+	}
+
+	if err := res.Err(); err != nil {
+		e.res.CloseWithError(err)
+		return
+	}
+
+	e.res.CloseCh()
+}
+
+func (b *Batch) sendBatch(ctx context.Context, entries []*batchEntry) ([]OperationResponse, http.Header, error) {
+	oreqs := make([]OperationRequest, 0, len(entries))
+	for _, e := range entries {
+		oreqs = append(oreqs, NewOperationRequestFromRequest(e.req))
+	}
+
+	bodyb, err := json.Marshal(oreqs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := b.Http.jsonReq(ctx, bodyb)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := b.Http.do(req, batchAccept, RequestOptions{TraceHeader: requestOptions(entries[0].req).TraceHeader})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if err := decompressBody(res); err != nil {
+		return nil, nil, err
+	}
+
+	results, err := readBatchResponse(res, b.Http.MaxResponseSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(results) != len(entries) {
+		return nil, nil, fmt.Errorf("batch response has %v results, expected %v", len(results), len(entries))
+	}
+
+	return results, res.Header, nil
+}
+
+// startsWithArray skips the leading whitespaces of body, and returns whether it holds an array
+func startsWithArray(body *bufio.Reader) (bool, error) {
+	for {
+		c, err := body.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+
+		_ = body.UnreadByte()
+
+		return c == '[', nil
+	}
+}
+
+// readBatchResponse decodes the array of results of a batch
+// It returns errBatchRejected when the server does not support batches, errBatchRefused when it refuses this
+// batch only (ie: a malformed query, or a batch too large)
+func readBatchResponse(res *http.Response, maxSize int64) ([]OperationResponse, error) {
+	body := bufio.NewReader(limitReader(res.Body, maxSize))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		switch res.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusUnsupportedMediaType:
+			return nil, errBatchRejected
+		case http.StatusRequestEntityTooLarge:
+			return nil, errBatchRefused
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			// A server supporting batches answers with an array of results, one that does not fails to decode
+			// the array (ie: gqlgen answers a 400 "json body could not be decoded")
+			if array, _ := startsWithArray(body); array {
+				return nil, errBatchRefused
+			}
+			return nil, errBatchRejected
+		}
+
+		head := &headBuffer{max: httpErrorBodyLimit}
+		head.fill(body)
+		return nil, newHTTPError(res, head.Bytes())
+	}
+
+	// The results must be an array
+	array, err := startsWithArray(body)
+	if err != nil {
+		return nil, err
+	}
+	if !array {
+		return nil, errBatchRejected
+	}
+
+	var results []OperationResponse
+	if err := json.NewDecoder(body).Decode(&results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -2,85 +2,324 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
 type HttpRequestOption func(req *http.Request)
 
+const (
+	// GraphQLResponseContentType is the GraphQL over HTTP response media type
+	GraphQLResponseContentType = "application/graphql-response+json"
+	// JSONContentType is the legacy response media type
+	JSONContentType = "application/json"
+	// MultipartMixedContentType is the incremental delivery (@defer, @stream) response media type
+	MultipartMixedContentType = "multipart/mixed"
+)
+
+// httpAccept prefers application/graphql-response+json, as per the GraphQL over HTTP spec,
+// and accepts incremental delivery for the operations using @defer or @stream
+const httpAccept = MultipartMixedContentType + ";deferSpec=20220824, " + GraphQLResponseContentType + ", " + JSONContentType + ";q=0.9"
+
 type Http struct {
 	URL string
 	// Client defaults to http.DefaultClient
 	Client           *http.Client
 	RequestOptions   []HttpRequestOption
 	UseFormMultipart bool
+	// UseGet sends queries as GET requests, with the operation encoded in the URL
+	// Mutations and requests with uploads are always POSTed
+	UseGet bool
+	// MaxGetURLLength is the max length of a GET request URL, longer requests are POSTed. Defaults to 2048
+	MaxGetURLLength int
+	// RequestCompression compresses the JSON request bodies with the given encoding (EncodingGzip, EncodingBrotli or
+	// EncodingZstd), the server must support it
+	RequestCompression string
+	// AcceptEncodings are the response encodings announced to the server (EncodingGzip, EncodingBrotli, EncodingZstd)
+	// When empty, the http.Client transparent gzip support applies
+	AcceptEncodings []string
+	// MaxResponseSize fails the responses larger than that many bytes (after decompression) with ErrHttpResponseTooLarge,
+	// for incremental delivery it applies to each part. 0 means no limit
+	MaxResponseSize int64
+	// Logger receives the requests (debug) and their responses (debug, warn when failed) events, with their
+	// method, URL without the query, headers, status and duration. Nothing is logged when nil
+	Logger Logger
+	// LogRedaction hides headers from the logged requests
+	LogRedaction Redaction
 }
 
 func (h *Http) Request(req Request) Response {
-	opres, err := h.request(req)
+	opts := requestOptions(req)
+
+	cancel := func() {}
+	if opts.Timeout > 0 {
+		req.Context, cancel = context.WithTimeout(req.Context, opts.Timeout)
+	}
+
+	res, err := h.request(req, opts)
 	if err != nil {
+		cancel()
 		return NewErrorResponse(err)
 	}
 
-	return NewSingleResponse(*opres)
-}
-
-func (h *Http) request(gqlreq Request) (*OperationResponse, error) {
-	if h.Client == nil {
-		h.Client = http.DefaultClient
+	if err := decompressBody(res); err != nil {
+		_ = res.Body.Close()
+		cancel()
+		return NewErrorResponse(err)
 	}
 
-	bodyb, err := json.Marshal(NewOperationRequestFromRequest(gqlreq))
-	if err != nil {
-		return nil, err
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		mediaType, params, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if mediaType == MultipartMixedContentType {
+			ires := newIncrementalResponse(res.Body, params["boundary"], res.Header, h.MaxResponseSize)
+			go func() {
+				<-ires.Done()
+				cancel()
+			}()
+
+			return ires
+		}
 	}
 
+	defer cancel()
+
+	return readHttpResponse(res, h.MaxResponseSize)
+}
+
+func (h *Http) request(gqlreq Request, opts RequestOptions) (*http.Response, error) {
 	var req *http.Request
-	if h.UseFormMultipart {
-		req, err = h.formReq(gqlreq, bodyb)
+	var err error
+	if h.UseGet && gqlreq.Operation == Query && len(h.collectUploads("variables", gqlreq.Variables)) == 0 {
+		req, err = h.getReq(gqlreq)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		req, err = http.NewRequestWithContext(gqlreq.Context, "POST", h.URL, bytes.NewReader(bodyb))
+	}
+
+	if req == nil {
+		req, err = h.postReq(gqlreq)
 		if err != nil {
 			return nil, err
 		}
-		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return h.do(req, httpAccept, opts)
+}
+
+func (h *Http) client() *http.Client {
+	if h.Client == nil {
+		return http.DefaultClient
+	}
+
+	return h.Client
+}
+
+// do sends req, with the transport RequestOptions then the per-request opts applied
+func (h *Http) do(req *http.Request, accept string, opts RequestOptions) (*http.Response, error) {
+	req.Header.Set("Accept", accept)
+	if len(h.AcceptEncodings) > 0 {
+		req.Header.Set("Accept-Encoding", strings.Join(h.AcceptEncodings, ", "))
 	}
 
 	for _, ro := range h.RequestOptions {
 		ro(req)
 	}
+	opts.apply(req)
 
-	res, err := h.Client.Do(req)
+	if h.Logger == nil {
+		return h.client().Do(req)
+	}
+
+	// The query string of GET requests holds the variables
+	u := logURL(req.URL.String())
+
+	h.Logger.Log(req.Context(), LogLevelDebug, "http request",
+		"method", req.Method,
+		"url", u,
+		"header", h.LogRedaction.RedactHeader(req.Header),
+	)
+
+	start := time.Now()
+	res, err := h.client().Do(req)
 	if err != nil {
+		h.Logger.Log(req.Context(), LogLevelWarn, "http request failed",
+			"method", req.Method,
+			"url", u,
+			"duration", time.Since(start),
+			"error", err,
+		)
 		return nil, err
 	}
+
+	level := LogLevelDebug
+	if res.StatusCode >= 400 {
+		level = LogLevelWarn
+	}
+	h.Logger.Log(req.Context(), level, "http response",
+		"method", req.Method,
+		"url", u,
+		"status", res.StatusCode,
+		"duration", time.Since(start),
+		"header", h.LogRedaction.RedactHeader(res.Header),
+	)
+
+	return res, nil
+}
+
+// readHttpResponse decodes a single GraphQL response from res, failing if the body is larger than maxSize (if > 0)
+func readHttpResponse(res *http.Response, maxSize int64) Response {
 	defer res.Body.Close()
 
-	data, err := ioutil.ReadAll(res.Body)
+	opres, err := decodeHttpResponse(res, limitReader(res.Body, maxSize))
 	if err != nil {
-		return nil, err
+		return NewErrorResponse(err)
 	}
+	opres.Header = res.Header
 
-	var opres OperationResponse
-	err = json.Unmarshal(data, &opres)
+	return NewSingleResponse(*opres)
+}
+
+// decodeHttpResponse applies the GraphQL over HTTP spec rules:
+// - 2xx responses must contain a GraphQL response
+// - non 2xx application/graphql-response+json responses are GraphQL responses if they are well-formed (ie: request errors)
+// - 4xx application/json responses are GraphQL responses if they hold errors, as sent by the servers predating the
+// spec (ie: gqlgen answers a 422 to invalid queries), but the 408 and 429 that come from the HTTP layer
+// - any other non 2xx response is returned as an *HTTPError, since it may come from an intermediary
+// The body is decoded as it is read (see decodeOperationResponse), only its beginning is kept aside for the errors
+func decodeHttpResponse(res *http.Response, body io.Reader) (*OperationResponse, error) {
+	success := res.StatusCode >= 200 && res.StatusCode < 300
+
+	head := &headBuffer{max: httpErrorBodyLimit}
+
+	legacy := false
+	if !success {
+		mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		legacy = mediaType == JSONContentType && res.StatusCode >= 400 && res.StatusCode < 500 &&
+			res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests
+
+		if mediaType != GraphQLResponseContentType && !legacy {
+			head.fill(body)
+			return nil, newHTTPError(res, head.Bytes())
+		}
+	}
+
+	opres, err := decodeOperationResponse(io.TeeReader(body, head))
 	if err != nil {
+		if errors.Is(err, ErrHttpResponseTooLarge) {
+			return nil, err
+		}
+
+		if !success {
+			head.fill(body)
+			return nil, newHTTPError(res, head.Bytes())
+		}
+
 		return nil, err
 	}
 
+	if legacy && len(opres.Errors) == 0 {
+		return nil, newHTTPError(res, head.Bytes())
+	}
+
 	if len(opres.Data) == 0 && len(opres.Errors) == 0 {
-		return nil, fmt.Errorf("no data nor errors, got %v: %.1000s", res.StatusCode, data)
+		if !success {
+			return nil, newHTTPError(res, head.Bytes())
+		}
+
+		return nil, fmt.Errorf("no data nor errors, got %v: %s", res.StatusCode, head.Bytes())
+	}
+
+	return opres, nil
+}
+
+func (h *Http) postReq(gqlreq Request) (*http.Request, error) {
+	bodyb, err := json.Marshal(NewOperationRequestFromRequest(gqlreq))
+	if err != nil {
+		return nil, err
+	}
+
+	if h.UseFormMultipart {
+		return h.formReq(gqlreq, bodyb)
+	}
+
+	return h.jsonReq(gqlreq.Context, bodyb)
+}
+
+// jsonReq builds a POST request with bodyb as JSON body, compressed with RequestCompression
+func (h *Http) jsonReq(ctx context.Context, bodyb []byte) (*http.Request, error) {
+	var err error
+	if h.RequestCompression != "" {
+		bodyb, err = compress(h.RequestCompression, bodyb)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewReader(bodyb))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.RequestCompression != "" {
+		req.Header.Set("Content-Encoding", h.RequestCompression)
+	}
+
+	return req, nil
+}
+
+// getReq builds a GET request for gqlreq, it returns a nil request if the URL would be longer than MaxGetURLLength
+func (h *Http) getReq(gqlreq Request) (*http.Request, error) {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return nil, err
 	}
 
-	return &opres, nil
+	q := u.Query()
+	if gqlreq.Query != "" {
+		q.Set("query", gqlreq.Query)
+	}
+	if gqlreq.OperationName != "" {
+		q.Set("operationName", gqlreq.OperationName)
+	}
+	if len(gqlreq.Variables) > 0 {
+		b, err := json.Marshal(gqlreq.Variables)
+		if err != nil {
+			return nil, err
+		}
+		q.Set("variables", string(b))
+	}
+	if len(gqlreq.Extensions) > 0 {
+		b, err := json.Marshal(gqlreq.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		q.Set("extensions", string(b))
+	}
+	u.RawQuery = q.Encode()
+
+	maxLength := h.MaxGetURLLength
+	if maxLength <= 0 {
+		maxLength = 2048
+	}
+
+	us := u.String()
+	if len(us) > maxLength {
+		return nil, nil
+	}
+
+	return http.NewRequestWithContext(gqlreq.Context, "GET", us, nil)
 }
 
 func (h *Http) jsonFormField(w *multipart.Writer, name string, v interface{}) error {
@@ -92,51 +331,162 @@ func (h *Http) jsonFormField(w *multipart.Writer, name string, v interface{}) er
 	return json.NewEncoder(fw).Encode(v)
 }
 
+type formFile struct {
+	key    string
+	upload Upload
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// formReq builds a multipart request, following https://github.com/jaydenseric/graphql-multipart-request-spec
+// The body is streamed, the uploads are read as the request is sent
+// Uploads sharing the same File are sent once, and referenced by all their paths in the map
 func (h *Http) formReq(gqlreq Request, bodyb []byte) (*http.Request, error) {
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
+	uploads := h.collectUploads("variables", gqlreq.Variables)
+
+	paths := make([]string, 0, len(uploads))
+	for p := range uploads {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
 
 	filesMap := make(map[string][]string)
+	files := make([]formFile, 0)
+	keys := make(map[interface{}]string)
 
-	i := 0
-	for p, f := range h.collectUploads("variables", gqlreq.Variables) {
-		k := fmt.Sprintf("%v", i)
-		fw, err := w.CreateFormFile(k, f.Name)
-		if err != nil {
-			return nil, err
-		}
+	for _, p := range paths {
+		f := uploads[p]
 
-		// Write file to field
-		if _, err := io.Copy(fw, f.File); err != nil {
-			return nil, err
+		key := f.key()
+		if key != nil {
+			if k, ok := keys[key]; ok {
+				filesMap[k] = append(filesMap[k], p)
+				continue
+			}
 		}
 
+		k := fmt.Sprintf("%v", len(files))
+		if key != nil {
+			keys[key] = k
+		}
 		filesMap[k] = []string{p}
-		i++
+		files = append(files, formFile{key: k, upload: f})
 	}
 
-	err := w.WriteField("operations", string(bodyb))
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+
+	req, err := http.NewRequestWithContext(gqlreq.Context, "POST", h.URL, pr)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
 
-	err = h.jsonFormField(w, "map", filesMap)
-	if err != nil {
-		return nil, err
+	if length, ok := h.formLength(w.Boundary(), bodyb, filesMap, files); ok {
+		req.ContentLength = length
 	}
 
-	err = w.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// The http client closes the body once done, which fails the writes
+		_ = pw.CloseWithError(h.writeForm(w, bodyb, filesMap, files))
+	}()
+
+	go func() {
+		select {
+		case <-gqlreq.Context.Done():
+			// Unblocks the http client even if an upload reader is blocked
+			_ = pw.CloseWithError(gqlreq.Context.Err())
+		case <-done:
+		}
+	}()
+
+	return req, nil
+}
+
+func (h *Http) writeFormFields(w *multipart.Writer, bodyb []byte, filesMap map[string][]string) error {
+	// operations and map must come first, so that servers can stream the files
+	err := w.WriteField("operations", string(bodyb))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	req, err := http.NewRequest("POST", h.URL, &b)
+	return h.jsonFormField(w, "map", filesMap)
+}
+
+func (h *Http) createFormFile(w *multipart.Writer, f formFile) (io.Writer, error) {
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(f.key), quoteEscaper.Replace(f.upload.Name)))
+	hdr.Set("Content-Type", f.upload.contentType())
+
+	return w.CreatePart(hdr)
+}
+
+func (h *Http) writeForm(w *multipart.Writer, bodyb []byte, filesMap map[string][]string, files []formFile) error {
+	err := h.writeFormFields(w, bodyb, filesMap)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
 
-	return req, nil
+	for _, f := range files {
+		fw, err := h.createFormFile(w, f)
+		if err != nil {
+			return err
+		}
+
+		r, err := f.upload.reader()
+		if err != nil {
+			return err
+		}
+
+		// Write file to field
+		if _, err := io.Copy(fw, r); err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+
+	return len(p), nil
+}
+
+// formLength computes the length of the form body, when the size of all the files is known
+func (h *Http) formLength(boundary string, bodyb []byte, filesMap map[string][]string, files []formFile) (int64, bool) {
+	var cw countingWriter
+	w := multipart.NewWriter(&cw)
+	if err := w.SetBoundary(boundary); err != nil {
+		return 0, false
+	}
+
+	if err := h.writeFormFields(w, bodyb, filesMap); err != nil {
+		return 0, false
+	}
+
+	var size int64
+	for _, f := range files {
+		if f.upload.Size <= 0 {
+			return 0, false
+		}
+
+		if _, err := h.createFormFile(w, f); err != nil {
+			return 0, false
+		}
+		size += f.upload.Size
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, false
+	}
+
+	return int64(cw) + size, true
 }
 
 func (h *Http) collectUploads(path string, in interface{}) map[string]Upload {
@@ -0,0 +1,461 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// https://github.com/enisdenjo/graphql-sse/blob/master/PROTOCOL.md
+
+const (
+	sseEventNext     = "next"
+	sseEventComplete = "complete"
+
+	// SseTokenHeader carries the event stream token in single connection mode
+	SseTokenHeader = "X-GraphQL-Event-Stream-Token"
+)
+
+// ErrSseStreamClosed is returned to the running operations when the single connection event stream ends
+var ErrSseStreamClosed = errors.New("sse stream closed")
+
+// Sse transports GQL operations over Server-Sent Events, following the graphql-sse protocol
+// By default every operation is its own event stream ("distinct connections" mode), with SingleConnection the operations
+// are multiplexed over one event stream, reserved on the first request
+// Close() must be called to dispose of the transport in single connection mode
+type Sse struct {
+	URL string
+	// Client defaults to http.DefaultClient, it should not have a Timeout since event streams are long-lived
+	Client         *http.Client
+	RequestOptions []HttpRequestOption
+	// SingleConnection multiplexes the operations over a single event stream
+	SingleConnection bool
+
+	stream *sseStream
+	m      sync.Mutex
+	i      uint64
+}
+
+type sseStream struct {
+	token  string
+	cancel context.CancelFunc
+	header http.Header
+
+	ops    map[string]*ChanResponse
+	closed bool
+	m      sync.Mutex
+}
+
+func (s *sseStream) add(id string, res *ChanResponse) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.ops[id] = res
+
+	return true
+}
+
+func (s *sseStream) get(id string) (*ChanResponse, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	res, ok := s.ops[id]
+
+	return res, ok
+}
+
+func (s *sseStream) remove(id string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	_, ok := s.ops[id]
+	delete(s.ops, id)
+
+	return ok
+}
+
+// close marks the stream closed and returns the operations that were running
+func (s *sseStream) close() map[string]*ChanResponse {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	ops := s.ops
+	s.ops = map[string]*ChanResponse{}
+	s.closed = true
+
+	return ops
+}
+
+func (s *Sse) Request(req Request) Response {
+	if s.SingleConnection {
+		return s.singleRequest(req)
+	}
+
+	return s.distinctRequest(req)
+}
+
+func (s *Sse) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+
+	return s.Client
+}
+
+func (s *Sse) newRequest(ctx context.Context, method string, u string, body interface{}) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", JSONContentType)
+	}
+
+	return req, nil
+}
+
+// do sends req, with the transport RequestOptions then the per-request opts applied
+func (s *Sse) do(req *http.Request, opts RequestOptions) (*http.Response, error) {
+	for _, ro := range s.RequestOptions {
+		ro(req)
+	}
+	opts.apply(req)
+
+	return s.client().Do(req)
+}
+
+func isEventStream(res *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+
+	return res.StatusCode >= 200 && res.StatusCode < 300 && mediaType == EventStreamContentType
+}
+
+func decodeSseResponse(data []byte) OperationResponse {
+	var opres OperationResponse
+	err := json.Unmarshal(data, &opres)
+	if err != nil {
+		opres.Errors = append(opres.Errors, gqlerror.WrapPath(nil, err))
+	}
+
+	return opres
+}
+
+func (s *Sse) distinctRequest(req Request) Response {
+	ctx, cancel := context.WithCancel(req.Context)
+
+	hreq, err := s.newRequest(ctx, "POST", s.URL, NewOperationRequestFromRequest(req))
+	if err != nil {
+		cancel()
+		return NewErrorResponse(err)
+	}
+	hreq.Header.Set("Accept", EventStreamContentType)
+
+	res, err := s.do(hreq, requestOptions(req))
+	if err != nil {
+		cancel()
+		return NewErrorResponse(err)
+	}
+
+	if !isEventStream(res) {
+		// Errors, or a server answering with a single response
+		defer cancel()
+		return readHttpResponse(res, 0)
+	}
+
+	cres := NewChanResponse(func() error {
+		cancel()
+		return nil
+	})
+
+	go func() {
+		defer cres.CloseCh()
+		defer res.Body.Close()
+
+		r := newSseReader(res.Body)
+		for {
+			ev, err := r.Next()
+			if err != nil {
+				select {
+				case <-cres.Done():
+					// Closed by the consumer
+				default:
+					if err != io.EOF {
+						cres.CloseWithError(err)
+					}
+				}
+				return
+			}
+
+			switch ev.Event {
+			case sseEventNext:
+				opres := decodeSseResponse(ev.Data)
+				opres.Header = res.Header
+				cres.Send(opres)
+			case sseEventComplete:
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return cres
+}
+
+func (s *Sse) getStream() (*sseStream, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.stream != nil {
+		return s.stream, nil
+	}
+
+	stream, err := s.openStream()
+	if err != nil {
+		return nil, err
+	}
+	s.stream = stream
+
+	return stream, nil
+}
+
+// openStream reserves an event stream, and connects to it
+func (s *Sse) openStream() (*sseStream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	hreq, err := s.newRequest(ctx, "PUT", s.URL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res, err := s.do(hreq, RequestOptions{})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		cancel()
+		return nil, newHTTPError(res, data)
+	}
+
+	stream := &sseStream{
+		token:  strings.TrimSpace(string(data)),
+		cancel: cancel,
+		ops:    map[string]*ChanResponse{},
+	}
+
+	hreq, err = s.newRequest(ctx, "GET", s.URL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	hreq.Header.Set("Accept", EventStreamContentType)
+	hreq.Header.Set(SseTokenHeader, stream.token)
+
+	res, err = s.do(hreq, RequestOptions{})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if !isEventStream(res) {
+		data, _ := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		cancel()
+		return nil, newHTTPError(res, data)
+	}
+
+	stream.header = res.Header
+
+	go s.readStream(stream, res.Body)
+
+	return stream, nil
+}
+
+func (s *Sse) readStream(stream *sseStream, body io.ReadCloser) {
+	defer body.Close()
+
+	r := newSseReader(body)
+	for {
+		ev, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = ErrSseStreamClosed
+			}
+			s.closeStream(stream, err)
+			return
+		}
+
+		var msg struct {
+			ID      string          `json:"id"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			continue
+		}
+
+		op, ok := stream.get(msg.ID)
+		if !ok {
+			continue
+		}
+
+		switch ev.Event {
+		case sseEventNext:
+			opres := decodeSseResponse(msg.Payload)
+			opres.Header = stream.header
+			op.Send(opres)
+		case sseEventComplete:
+			stream.remove(msg.ID)
+			op.CloseCh()
+		}
+	}
+}
+
+// closeStream closes stream and its operations, with err if it is non-nil
+func (s *Sse) closeStream(stream *sseStream, err error) {
+	s.m.Lock()
+	if s.stream == stream {
+		s.stream = nil
+	}
+	s.m.Unlock()
+
+	stream.cancel()
+
+	for _, op := range stream.close() {
+		if err != nil {
+			op.CloseWithError(err)
+		} else {
+			op.CloseCh()
+		}
+	}
+}
+
+func (s *Sse) singleRequest(req Request) Response {
+	stream, err := s.getStream()
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	id := strconv.FormatUint(atomic.AddUint64(&s.i, 1), 10)
+
+	res := NewChanResponse(func() error {
+		return s.stopOp(stream, id)
+	})
+	if !stream.add(id, res) {
+		return NewErrorResponse(ErrSseStreamClosed)
+	}
+
+	oreq := NewOperationRequestFromRequest(req)
+	oreq.Extensions = make(map[string]interface{}, len(req.Extensions)+1)
+	for k, v := range req.Extensions {
+		oreq.Extensions[k] = v
+	}
+	oreq.Extensions["operationId"] = id
+
+	hreq, err := s.newRequest(req.Context, "POST", s.URL, oreq)
+	if err != nil {
+		stream.remove(id)
+		return NewErrorResponse(err)
+	}
+	hreq.Header.Set(SseTokenHeader, stream.token)
+
+	hres, err := s.do(hreq, requestOptions(req))
+	if err != nil {
+		stream.remove(id)
+		return NewErrorResponse(err)
+	}
+
+	data, err := ioutil.ReadAll(hres.Body)
+	_ = hres.Body.Close()
+	if err != nil {
+		stream.remove(id)
+		return NewErrorResponse(err)
+	}
+
+	if hres.StatusCode < 200 || hres.StatusCode >= 300 {
+		stream.remove(id)
+		return NewErrorResponse(newHTTPError(hres, data))
+	}
+
+	return res
+}
+
+// stopOp tells the server to stop the operation, if it is still running
+func (s *Sse) stopOp(stream *sseStream, id string) error {
+	if !stream.remove(id) {
+		return nil
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("operationId", id)
+	u.RawQuery = q.Encode()
+
+	hreq, err := s.newRequest(context.Background(), "DELETE", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set(SseTokenHeader, stream.token)
+
+	res, err := s.do(hreq, RequestOptions{})
+	if err != nil {
+		return err
+	}
+
+	data, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return newHTTPError(res, data)
+	}
+
+	return nil
+}
+
+func (s *Sse) Close() error {
+	s.m.Lock()
+	stream := s.stream
+	s.stream = nil
+	s.m.Unlock()
+
+	if stream != nil {
+		s.closeStream(stream, nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSseServer is a minimal graphql-sse server, operations get count (from their variables) next events
+// then complete, a negative count streams until the operation is stopped
+type fakeSseServer struct {
+	*httptest.Server
+
+	reservations int32
+	lastHeader   atomic.Value
+	stream       chan string
+	streamDone   chan struct{}
+	stopped      chan string
+}
+
+func newFakeSseServer() *fakeSseServer {
+	s := &fakeSseServer{
+		stream:     make(chan string, 100),
+		streamDone: make(chan struct{}, 10),
+		stopped:    make(chan string, 10),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+func (s *fakeSseServer) writeEvent(w http.ResponseWriter, event string, data string) {
+	_, _ = fmt.Fprintf(w, "event: %v\ndata: %v\n\n", event, data)
+	w.(http.Flusher).Flush()
+}
+
+func (s *fakeSseServer) count(oreq OperationRequest) int {
+	count, _ := oreq.Variables["count"].(float64)
+
+	return int(count)
+}
+
+func (s *fakeSseServer) handle(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(SseTokenHeader)
+
+	switch {
+	case r.Method == "PUT":
+		atomic.AddInt32(&s.reservations, 1)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("tok"))
+	case r.Method == "GET":
+		w.Header().Set("Content-Type", EventStreamContentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(": connected\n\n"))
+		w.(http.Flusher).Flush()
+
+		for {
+			select {
+			case ev := <-s.stream:
+				_, _ = w.Write([]byte(ev))
+				w.(http.Flusher).Flush()
+			case <-s.streamDone:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	case r.Method == "DELETE":
+		s.stopped <- r.URL.Query().Get("operationId")
+	case r.Method == "POST" && token != "":
+		var oreq OperationRequest
+		_ = json.NewDecoder(r.Body).Decode(&oreq)
+		id, _ := oreq.Extensions["operationId"].(string)
+
+		w.WriteHeader(http.StatusAccepted)
+
+		go func() {
+			for i := 0; i < s.count(oreq); i++ {
+				s.stream <- fmt.Sprintf("event: next\ndata: {\"id\":%q,\"payload\":{\"data\":%v}}\n\n", id, i)
+			}
+			if s.count(oreq) >= 0 {
+				s.stream <- fmt.Sprintf("event: complete\ndata: {\"id\":%q}\n\n", id)
+			}
+		}()
+	case r.Method == "POST":
+		s.lastHeader.Store(r.Header)
+
+		var oreq OperationRequest
+		_ = json.NewDecoder(r.Body).Decode(&oreq)
+
+		if r.Header.Get("Accept") != EventStreamContentType {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+
+		if oreq.Query == "unauthorized" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", EventStreamContentType)
+		w.WriteHeader(http.StatusOK)
+
+		count := s.count(oreq)
+		for i := 0; count < 0 || i < count; i++ {
+			select {
+			case <-r.Context().Done():
+				s.stopped <- "distinct"
+				return
+			default:
+			}
+
+			s.writeEvent(w, "next", fmt.Sprintf(`{"data":%v}`, i))
+			if count < 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		s.writeEvent(w, "complete", "")
+	}
+}
+
+func (s *fakeSseServer) expectStopped(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case id := <-s.stopped:
+		return id
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for stop")
+	}
+
+	return ""
+}
+
+func sseRequest(count int) Request {
+	return Request{
+		Context:   context.Background(),
+		Operation: Subscription,
+		Query:     "subscription { count }",
+		Variables: map[string]interface{}{"count": count},
+	}
+}
+
+func collectData(t *testing.T, res Response) []string {
+	t.Helper()
+
+	var data []string
+	for res.Next() {
+		data = append(data, string(res.Get().Data))
+	}
+	assert.NoError(t, res.Err())
+
+	return data
+}
+
+func TestSseDistinct(t *testing.T) {
+	srv := newFakeSseServer()
+	defer srv.Close()
+
+	tr := &Sse{URL: srv.URL}
+
+	res := tr.Request(sseRequest(3))
+	defer res.Close()
+
+	assert.Equal(t, []string{"0", "1", "2"}, collectData(t, res))
+}
+
+func TestSseDistinctClose(t *testing.T) {
+	srv := newFakeSseServer()
+	defer srv.Close()
+
+	tr := &Sse{URL: srv.URL}
+
+	res := tr.Request(sseRequest(-1))
+
+	opres := nextResponse(t, res)
+	assert.Equal(t, "0", string(opres.Data))
+
+	res.Close()
+
+	assert.Equal(t, "distinct", srv.expectStopped(t))
+}
+
+func TestSseDistinctHTTPError(t *testing.T) {
+	srv := newFakeSseServer()
+	defer srv.Close()
+
+	tr := &Sse{URL: srv.URL}
+
+	res := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "unauthorized"})
+	defer res.Close()
+
+	assert.False(t, res.Next())
+
+	var herr *HTTPError
+	if assert.True(t, errors.As(res.Err(), &herr)) {
+		assert.Equal(t, http.StatusUnauthorized, herr.StatusCode)
+	}
+}
+
+func TestSseDistinctRequestOptions(t *testing.T) {
+	srv := newFakeSseServer()
+	defer srv.Close()
+
+	tr := &Sse{
+		URL: srv.URL,
+		RequestOptions: []HttpRequestOption{func(req *http.Request) {
+			req.Header.Set("X-Tenant", "transport")
+		}},
+	}
+
+	req := sseRequest(1)
+	req.Options.SetHeader("X-Tenant", "request")
+
+	res := tr.Request(req)
+	defer res.Close()
+
+	opres := nextResponse(t, res)
+	assert.Equal(t, EventStreamContentType, opres.Header.Get("Content-Type"))
+	assert.Equal(t, "request", srv.lastHeader.Load().(http.Header).Get("X-Tenant"))
+}
+
+func TestSseSingleConnection(t *testing.T) {
+	srv := newFakeSseServer()
+	defer srv.Close()
+
+	tr := &Sse{URL: srv.URL, SingleConnection: true}
+	defer tr.Close()
+
+	res1 := tr.Request(sseRequest(2))
+	defer res1.Close()
+	res2 := tr.Request(sseRequest(3))
+	defer res2.Close()
+
+	assert.Equal(t, []string{"0", "1"}, collectData(t, res1))
+	assert.Equal(t, []string{"0", "1", "2"}, collectData(t, res2))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.reservations))
+}
+
+func TestSseSingleConnectionStop(t *testing.T) {
+	srv := newFakeSseServer()
+	defer srv.Close()
+
+	tr := &Sse{URL: srv.URL, SingleConnection: true}
+	defer tr.Close()
+
+	res := tr.Request(sseRequest(-1))
+	res.Close()
+
+	assert.Equal(t, "1", srv.expectStopped(t))
+}
+
+func TestSseSingleConnectionStreamClosed(t *testing.T) {
+	srv := newFakeSseServer()
+	defer srv.Close()
+
+	tr := &Sse{URL: srv.URL, SingleConnection: true}
+	defer tr.Close()
+
+	res := tr.Request(sseRequest(-1))
+	defer res.Close()
+
+	srv.streamDone <- struct{}{}
+
+	assert.False(t, res.Next())
+	assert.Equal(t, ErrSseStreamClosed, res.Err())
+
+	// A new stream is reserved
+	res = tr.Request(sseRequest(1))
+	defer res.Close()
+
+	assert.Equal(t, []string{"0"}, collectData(t, res))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.reservations))
+}
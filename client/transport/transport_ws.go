@@ -9,8 +9,6 @@ import (
 	"github.com/vektah/gqlparser/v2/gqlerror"
 	"io"
 	"nhooyr.io/websocket"
-	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,7 +21,7 @@ const (
 	// GQL_CONNECTION_INIT the Client sends this message after plain websocket connection to start the communication with the server
 	GQL_CONNECTION_INIT OperationMessageType = "connection_init"
 	// GQL_CONNECTION_ERROR The server may responses with this message to the GQL_CONNECTION_INIT from client, indicates the server rejected the connection.
-	GQL_CONNECTION_ERROR OperationMessageType = "conn_err"
+	GQL_CONNECTION_ERROR OperationMessageType = "connection_error"
 	// GQL_START Client sends this message to execute GraphQL operation
 	GQL_START OperationMessageType = "start"
 	// GQL_STOP Client sends this message in order to stop a running GraphQL operation execution (for example: unsubscribe)
@@ -42,6 +40,15 @@ const (
 	// GQL_CONNECTION_TERMINATE the Client sends this message to terminate the connection.
 	GQL_CONNECTION_TERMINATE OperationMessageType = "connection_terminate"
 
+	// GQL_SUBSCRIBE Client sends this message to execute GraphQL operation (graphql-transport-ws replacement of GQL_START)
+	GQL_SUBSCRIBE OperationMessageType = "subscribe"
+	// GQL_NEXT The server sends this message to transfer the GraphQL execution result (graphql-transport-ws replacement of GQL_DATA)
+	GQL_NEXT OperationMessageType = "next"
+	// GQL_PING Bidirectional, can be sent at any time to check the connection, the receiver must answer with GQL_PONG (graphql-transport-ws only)
+	GQL_PING OperationMessageType = "ping"
+	// GQL_PONG Bidirectional, response to GQL_PING, may also be sent unsolicited as an unidirectional heartbeat (graphql-transport-ws only)
+	GQL_PONG OperationMessageType = "pong"
+
 	// GQL_UNKNOWN is an Unknown operation type, for logging only
 	GQL_UNKNOWN OperationMessageType = "unknown"
 	// GQL_INTERNAL is the Internal status, for logging only
@@ -58,6 +65,20 @@ type WebsocketConn interface {
 	SetReadLimit(limit int64)
 }
 
+// WebsocketConnSubprotocol can be implemented by a WebsocketConn to report the negotiated subprotocol
+type WebsocketConnSubprotocol interface {
+	Subprotocol() string
+}
+
+type WsSubprotocol string
+
+const (
+	// SubprotocolGraphqlWs is the legacy subscriptions-transport-ws protocol https://github.com/apollographql/subscriptions-transport-ws/blob/master/PROTOCOL.md
+	SubprotocolGraphqlWs WsSubprotocol = "graphql-ws"
+	// SubprotocolGraphqlTransportWs is the graphql-ws protocol https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+	SubprotocolGraphqlTransportWs WsSubprotocol = "graphql-transport-ws"
+)
+
 type OperationMessage struct {
 	ID      string               `json:"id,omitempty"`
 	Type    OperationMessageType `json:"type"`
@@ -79,10 +100,18 @@ type wsResponse struct {
 	Context          context.Context
 	OperationRequest OperationRequest
 	started          bool
+	startedOnce      bool
+
+	// resumable is set when the request opted into the resume semantics, see WithResubscribe
+	resumable   bool
+	resubscribe ResubscribeFunc
+	last        *OperationResponse
 }
 
 type WebsocketConnProvider func(ctx context.Context, URL string) (WebsocketConn, error)
 
+type ConnectionParamsProvider func(ctx context.Context) (interface{}, error)
+
 type Status int
 
 const (
@@ -105,38 +134,116 @@ func (s Status) String() string {
 }
 
 // Ws transports GQL queries over websocket
-// Start() must be called to initiate the websocket connection
+// Start() must be called to initiate the websocket connection, unless Lazy is set
 // Close() must be called to dispose of the transport
+// The protocol (graphql-ws or graphql-transport-ws) is picked from the subprotocol negotiated by the WebsocketConn,
+// see Subprotocol when the WebsocketConn does not implement WebsocketConnSubprotocol
 type Ws struct {
 	URL string
+	// Subprotocol is the protocol spoken when the WebsocketConn does not report the negotiated one (ie: a wrapper
+	// not implementing WebsocketConnSubprotocol), or when the server did not pick one. Defaults to graphql-ws.
+	// A connection negotiating another protocol than a set Subprotocol fails with ErrWsSubprotocolMismatch
+	Subprotocol WsSubprotocol
 	// WebsocketConnProvider defaults to DefaultWebsocketConnProvider(30 * time.Second)
 	WebsocketConnProvider WebsocketConnProvider
 	// ConnectionParams will be sent during the connection init
 	ConnectionParams interface{}
-
-	cancel  context.CancelFunc
-	conn    WebsocketConn
+	// ConnectionParamsProvider is called before every connection init, its result is sent instead of ConnectionParams.
+	// Useful to refresh expiring credentials on reconnection
+	ConnectionParamsProvider ConnectionParamsProvider
+	// ConnectionInitTimeout is the max duration to wait for the connection ack after sending the connection init,
+	// the connection is reset with ErrWsConnectionInitTimeout when exceeded. 0 means no timeout
+	ConnectionInitTimeout time.Duration
+	// ReconnectBackoff is consulted after each connection attempt that did not reach StatusReady,
+	// once it gives up the transport stops and pending operations fail with ErrWsReconnectAborted.
+	// Defaults to ConstantBackoff(time.Second)
+	ReconnectBackoff Backoff
+	// KeepAliveTimeoutFactor enables the dead connection detection: once the keep alive interval is known, the connection is
+	// reset with ErrWsKeepAliveTimeout when no message has been received for KeepAliveTimeoutFactor times that interval.
+	// The interval is PingInterval for graphql-transport-ws when set, otherwise it is measured between the server keep alives.
+	// When enabled, the WebsocketConnProvider read timeout can be disabled. 0 disables the detection
+	KeepAliveTimeoutFactor int
+	// PingInterval is the interval at which pings are sent to the server with graphql-transport-ws, 0 disables pings
+	PingInterval time.Duration
+	// ResponseBuffer configures the buffering of the operations responses, it can be overridden per operation with
+	// WithResponseBuffer. The default (unbuffered, OverflowBlock) makes a slow consumer stall the whole connection
+	ResponseBuffer ResponseBuffer
+	// Hooks are notified of the connection lifecycle
+	Hooks WsHooks
+	// Lazy makes the connection start on the first request instead of Start(), which must not be called.
+	// The connection is closed IdleTimeout after the last operation is done, and started again on the next request
+	Lazy bool
+	// IdleTimeout only applies to Lazy, 0 closes the connection as soon as the last operation is done
+	IdleTimeout time.Duration
+	// Logger receives the connection lifecycle (info and warn, with the URL without its query) and protocol messages
+	// (debug) events.
+	// Defaults to a debug logger on stdout when GQLGENC_WS_LOG is set (deprecated), nothing is logged otherwise
+	Logger Logger
+	// LogRedaction hides variables from the logged operations
+	LogRedaction Redaction
+
+	// conn, protocol and cancel are guarded by cm
+	cancel   context.CancelFunc
+	conn     WebsocketConn
+	protocol WsSubprotocol
+	cm       sync.RWMutex
+
+	ack atomic.Value
+	ka  *keepAlive
+
+	// running and status are guarded by sc.L
 	running bool
 	status  Status
 	sc      *sync.Cond
 
-	ops  map[string]*wsResponse
-	opsm sync.Mutex
+	// ops, the ops started flags, err and the lazy state are guarded by opsm
+	ops     map[string]*wsResponse
+	opsm    sync.Mutex
+	unknown uint64
+	// err is the terminal error, set when the transport gave up reconnecting
+	err     error
+	lastErr error
+
+	lazyCancel context.CancelFunc
+	lazyDone   chan struct{}
+	idleTimer  *time.Timer
 
 	o     sync.Once
 	errCh chan error
+	em    sync.Mutex
 	i     uint64
 	rm    sync.Mutex
-	log   bool
+
+	logger Logger
 }
 
 func (t *Ws) sendErr(err error) {
+	t.em.Lock()
+	defer t.em.Unlock()
+
 	select {
 	case t.errCh <- err: // Attempt to write err
 	default:
 	}
 }
 
+func (t *Ws) openErrCh() chan error {
+	t.em.Lock()
+	defer t.em.Unlock()
+
+	t.errCh = make(chan error)
+
+	return t.errCh
+}
+
+func (t *Ws) closeErrCh() {
+	t.em.Lock()
+	defer t.em.Unlock()
+
+	close(t.errCh)
+	t.errCh = nil
+}
+
 func (t *Ws) init() {
 	t.o.Do(func() {
 		t.ops = make(map[string]*wsResponse)
@@ -149,14 +256,21 @@ func (t *Ws) init() {
 			t.WebsocketConnProvider = DefaultWebsocketConnProvider(30 * time.Second)
 		}
 
-		t.log, _ = strconv.ParseBool(os.Getenv("GQLGENC_WS_LOG"))
+		if t.ReconnectBackoff == nil {
+			t.ReconnectBackoff = ConstantBackoff(time.Second)
+		}
+
+		t.logger = t.Logger
+		if t.logger == nil {
+			t.logger = envLogger()
+		}
 	})
 }
 
 func (t *Ws) WaitFor(st Status, s time.Duration) {
 	t.init()
 
-	t.printLog(GQL_INTERNAL, "WAIT FOR", st)
+	t.logEvent(LogLevelDebug, "ws wait for status", "status", st)
 
 	for {
 		t.waitFor(st)
@@ -164,12 +278,12 @@ func (t *Ws) WaitFor(st Status, s time.Duration) {
 		time.Sleep(s)
 
 		// After timeout, is it still in the expected status ?
-		if t.status == st {
+		if t.getStatus() == st {
 			break
 		}
 	}
 
-	t.printLog(GQL_INTERNAL, "DONE WAIT FOR", st)
+	t.logEvent(LogLevelDebug, "ws done waiting for status", "status", st)
 }
 
 func (t *Ws) waitFor(s Status) {
@@ -183,8 +297,12 @@ func (t *Ws) waitFor(s Status) {
 }
 
 func (t *Ws) setRunning(v bool) {
-	t.printLog(GQL_INTERNAL, "SET ISRUNNING", v)
+	t.logEvent(LogLevelDebug, "ws set running", "running", v)
+
+	t.sc.L.Lock()
 	t.running = v
+	t.sc.L.Unlock()
+
 	if v == false {
 		t.setStatus(StatusDisconnected)
 	} else {
@@ -192,173 +310,317 @@ func (t *Ws) setRunning(v bool) {
 	}
 }
 
+func (t *Ws) isRunning() bool {
+	t.sc.L.Lock()
+	defer t.sc.L.Unlock()
+
+	return t.running
+}
+
 func (t *Ws) setStatus(s Status) {
+	t.sc.L.Lock()
+	defer t.sc.L.Unlock()
+
 	if t.status == s {
 		return
 	}
 
-	t.printLog(GQL_INTERNAL, "SET STATUS", s)
+	t.logEvent(LogLevelDebug, "ws status", "status", s)
 	t.status = s
 	t.sc.Broadcast()
 }
 
+func (t *Ws) getStatus() Status {
+	t.sc.L.Lock()
+	defer t.sc.L.Unlock()
+
+	return t.status
+}
+
 func (t *Ws) Start(ctx context.Context) <-chan error {
 	t.init()
 
-	if t.running {
+	if t.Lazy {
+		panic("Start must not be called on a lazy transport")
+	}
+
+	if t.isRunning() {
 		panic("transport is already running")
 	}
 
-	t.errCh = make(chan error)
+	errCh := t.openErrCh()
+
+	t.opsm.Lock()
+	t.err = nil
+	t.opsm.Unlock()
 
 	go t.run(ctx)
 
-	return t.errCh
+	return errCh
+}
+
+func (t *Ws) getConn() (WebsocketConn, WsSubprotocol) {
+	t.cm.RLock()
+	defer t.cm.RUnlock()
+
+	return t.conn, t.protocol
 }
 
 func (t *Ws) readJson(v interface{}) error {
-	return t.conn.ReadJSON(v)
+	conn, _ := t.getConn()
+
+	return conn.ReadJSON(v)
 }
 
 func (t *Ws) writeJson(v interface{}) error {
-	return t.conn.WriteJSON(v)
+	conn, _ := t.getConn()
+
+	return conn.WriteJSON(v)
+}
+
+func (t *Ws) getProtocol() WsSubprotocol {
+	_, protocol := t.getConn()
+
+	return protocol
 }
 
 func (t *Ws) run(inctx context.Context) {
+	var exitErr error
 	defer func() {
+		if t.getStatus() != StatusDisconnected {
+			t.Hooks.disconnect(exitErr)
+		}
 		t.setRunning(false)
-		close(t.errCh)
+		t.closeErrCh()
 	}()
 
 	t.setRunning(true)
 
+	// attempt counts the connection attempts since the last time the connection was ready
+	var attempt int
+	var firstAttempt time.Time
+	var reconnecting bool
+
 	ctx := inctx
 	for {
-		//t.printLog(GQL_INTERNAL, "STATUS", t.status)
-
 		select {
 		case <-inctx.Done():
-			err := inctx.Err()
-			t.printLog(GQL_INTERNAL, "CTX DONE", err)
-			t.sendErr(err)
+			exitErr = inctx.Err()
+			t.logEvent(LogLevelInfo, "ws stopped", "error", exitErr)
+			t.sendErr(exitErr)
 			return
 		default:
 			// continue...
 		}
 
-		if t.status == StatusDisconnected {
-			t.printLog(GQL_INTERNAL, "CANCEL PREV CTX")
+		if t.getStatus() == StatusDisconnected {
+			if attempt > 0 {
+				d, ok := t.ReconnectBackoff.Next(attempt, time.Since(firstAttempt))
+				if !ok {
+					t.rm.Lock()
+					lastErr := t.lastErr
+					t.rm.Unlock()
 
+					t.abort(fmt.Errorf("%w after %v attempts: %v", ErrWsReconnectAborted, attempt, lastErr))
+					return
+				}
+
+				t.logEvent(LogLevelInfo, "ws reconnect backoff", "attempt", attempt, "delay", d)
+				if !sleepCtx(inctx, d) {
+					continue
+				}
+			} else {
+				firstAttempt = time.Now()
+			}
+			attempt++
+
+			if reconnecting {
+				t.Hooks.reconnect(attempt)
+			}
+			reconnecting = true
+
+			t.logEvent(LogLevelDebug, "ws cancel previous connection")
+
+			t.cm.Lock()
 			if t.cancel != nil {
 				t.cancel()
 			}
 			ctx, t.cancel = context.WithCancel(inctx)
+			t.cm.Unlock()
 
-			t.printLog(GQL_INTERNAL, "CONNECTING")
+			t.logEvent(LogLevelInfo, "ws connecting", "url", logURL(t.URL))
 			conn, err := t.WebsocketConnProvider(ctx, t.URL)
 			if err != nil {
-				t.printLog(GQL_INTERNAL, "WebsocketConnProvider ERR", err)
+				t.logEvent(LogLevelWarn, "ws connection failed", "error", err)
+				t.ResetWithErr(err)
+				continue
+			}
+			t.logEvent(LogLevelDebug, "ws has connection")
+			protocol, err := t.connProtocol(conn)
+			if err != nil {
+				t.logEvent(LogLevelWarn, "ws subprotocol", "error", err)
+				_ = conn.Close()
 				t.ResetWithErr(err)
-				time.Sleep(time.Second)
 				continue
 			}
-			t.printLog(GQL_INTERNAL, "HAS CONN")
+			t.cm.Lock()
 			t.conn = conn
+			t.protocol = protocol
+			t.cm.Unlock()
+			t.ka = nil
+			t.logEvent(LogLevelDebug, "ws protocol", "protocol", protocol)
 			t.setStatus(StatusConnected)
+			t.Hooks.connect()
 
-			err = t.sendConnectionInit()
+			err = t.sendConnectionInit(ctx)
 			if err != nil {
-				t.printLog(GQL_INTERNAL, "sendConnectionInit ERR", err)
+				t.logEvent(LogLevelWarn, "ws connection init failed", "error", err)
 				t.ResetWithErr(err)
-				time.Sleep(time.Second)
 				continue
 			}
 
-			t.printLog(GQL_INTERNAL, "CONNECTED")
+			if t.ConnectionInitTimeout > 0 {
+				go t.watchConnectionInit(ctx)
+			}
+
+			t.logEvent(LogLevelInfo, "ws connected", "protocol", protocol)
 		}
 
 		var message OperationMessage
 		if err := t.readJson(&message); err != nil {
+			if t.getStatus() == StatusDisconnected {
+				// The connection has been reset while reading, reconnect
+				t.logEvent(LogLevelDebug, "ws read after reset", "error", err)
+				continue
+			}
+
 			// Is expected as part of conn.ReadJSON timeout, we have not received a message or
 			// a KA, the connection is probably dead... RIP
 			if errors.Is(err, context.DeadlineExceeded) {
-				t.printLog(GQL_INTERNAL, "READ DEADLINE EXCEEDED")
+				t.logEvent(LogLevelWarn, "ws read deadline exceeded")
 				t.ResetWithErr(err)
 				continue
 			}
 
+			if cerr := toWsCloseError(err); cerr != nil {
+				t.logEvent(LogLevelWarn, "ws closed by server", "error", cerr)
+				t.ResetWithErr(cerr)
+				continue
+			}
+
 			if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "EOF") {
-				t.printLog(GQL_INTERNAL, "EOF")
+				t.logEvent(LogLevelWarn, "ws connection lost", "error", err)
 				t.ResetWithErr(err)
 				continue
 			}
 
 			closeStatus := websocket.CloseStatus(err)
 			if closeStatus == websocket.StatusNormalClosure {
-				t.printLog(GQL_INTERNAL, "NORMAL CLOSURE")
+				t.logEvent(LogLevelInfo, "ws normal closure")
 				// close event from websocket client, exiting...
 				return
 			}
 
-			t.printLog(GQL_INTERNAL, "READ JSON ERR", err)
+			t.logEvent(LogLevelWarn, "ws read failed", "error", err)
 			t.ResetWithErr(err)
 			continue
 		}
 
+		if t.ka != nil {
+			t.ka.message(message.Type == GQL_CONNECTION_KEEP_ALIVE || message.Type == GQL_PING || message.Type == GQL_PONG)
+		}
+
 		switch message.Type {
 		case GQL_CONNECTION_ACK:
-			t.printLog(GQL_CONNECTION_ACK, message)
+			t.logMessage("received", message)
+			t.ack.Store(message.Payload)
 			t.setStatus(StatusReady)
+			attempt = 0
+			t.Hooks.ack(message.Payload)
+
+			if t.KeepAliveTimeoutFactor > 0 {
+				t.ka = t.startKeepAlive(ctx)
+			}
+			if t.getProtocol() == SubprotocolGraphqlTransportWs && t.PingInterval > 0 {
+				go t.ping(ctx)
+			}
+
+			failed := make(map[string]error)
+			resubscribed := make([]*wsResponse, 0)
 
 			t.opsm.Lock()
 			for id, op := range t.ops {
+				resubscribe := op.resumable && op.startedOnce && !op.started
+				if resubscribe && op.resubscribe != nil {
+					op.OperationRequest.Variables = op.resubscribe(op.OperationRequest.Variables, op.last)
+				}
+
 				if err := t.startOp(id, op); err != nil {
-					t.printLog(GQL_INTERNAL, "ACK: START OP FAILED")
-					_ = t.cancelOp(id)
-					t.sendErr(err)
+					failed[id] = err
+					continue
+				}
+
+				if resubscribe {
+					resubscribed = append(resubscribed, op)
 				}
 			}
 			t.opsm.Unlock()
+
+			for id, err := range failed {
+				t.logEvent(LogLevelWarn, "ws start operation failed", "id", id, "error", err)
+				_ = t.cancelOp(id)
+				t.sendErr(err)
+			}
+
+			for _, op := range resubscribed {
+				op.Send(NewResubscribedOperationResponse())
+			}
 		case GQL_CONNECTION_KEEP_ALIVE:
-			t.printLog(GQL_CONNECTION_KEEP_ALIVE, message)
+			t.logMessage("received", message)
+			t.Hooks.keepAlive()
 		case GQL_CONNECTION_ERROR:
-			t.printLog(GQL_CONNECTION_ERROR, message)
-			t.setStatus(StatusDisconnected)
-			t.ResetWithErr(fmt.Errorf("gql conn error: %v", message))
-		case GQL_COMPLETE:
-			t.printLog(GQL_COMPLETE, message)
-			_ = t.cancelOp(message.ID)
-		case GQL_ERROR:
-			t.printLog(GQL_ERROR, message)
-			fallthrough
-		case GQL_DATA:
-			t.printLog(GQL_DATA, message)
-
-			id := message.ID
-			t.opsm.Lock()
-			op, ok := t.ops[id]
-			if !ok {
-				continue
+			t.logMessage("received", message, "payload", string(message.Payload))
+			t.ResetWithErr(&WsConnectionError{Payload: message.Payload})
+		case GQL_PING:
+			t.logMessage("received", message)
+			t.Hooks.keepAlive()
+
+			msg := OperationMessage{
+				Type:    GQL_PONG,
+				Payload: message.Payload,
 			}
-			t.opsm.Unlock()
 
-			var out OperationResponse
-			err := json.Unmarshal(message.Payload, &out)
-			if err != nil {
-				out.Errors = append(out.Errors, gqlerror.WrapPath(nil, err))
+			t.logMessage("sent", msg)
+			if err := t.writeJson(msg); err != nil {
+				t.logEvent(LogLevelWarn, "ws pong failed", "error", err)
+				t.ResetWithErr(err)
 			}
-			op.Send(out)
+		case GQL_PONG:
+			t.logMessage("received", message)
+			t.Hooks.keepAlive()
+		case GQL_COMPLETE, GQL_ERROR, GQL_DATA, GQL_NEXT:
+			t.logMessage("received", message)
+			t.dispatch(message)
 		default:
-			t.printLog(GQL_UNKNOWN, message)
+			t.logMessage("received", message)
 		}
 	}
 }
 
-func (t *Ws) sendConnectionInit() error {
+func (t *Ws) sendConnectionInit(ctx context.Context) error {
+	params := t.ConnectionParams
+	if t.ConnectionParamsProvider != nil {
+		var err error
+		params, err = t.ConnectionParamsProvider(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	var bParams []byte = nil
-	if t.ConnectionParams != nil {
+	if params != nil {
 		var err error
-		bParams, err = json.Marshal(t.ConnectionParams)
+		bParams, err = json.Marshal(params)
 		if err != nil {
 			return err
 		}
@@ -369,10 +631,107 @@ func (t *Ws) sendConnectionInit() error {
 		Payload: bParams,
 	}
 
-	t.printLog(GQL_CONNECTION_INIT, msg)
+	t.logMessage("sent", msg)
 	return t.writeJson(msg)
 }
 
+// abort fails all pending operations with err, further requests will fail with err until Start is called again
+func (t *Ws) abort(err error) {
+	t.logEvent(LogLevelError, "ws aborted", "error", err)
+
+	t.opsm.Lock()
+	if t.Lazy {
+		// The next request will start over
+		if cancel := t.lazyStop(); cancel != nil {
+			defer cancel()
+		}
+	} else {
+		t.err = err
+	}
+	ops := t.ops
+	t.ops = make(map[string]*wsResponse)
+	t.opsm.Unlock()
+
+	for _, op := range ops {
+		op.CloseWithError(err)
+	}
+
+	t.sendErr(err)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (t *Ws) startKeepAlive(ctx context.Context) *keepAlive {
+	var interval time.Duration
+	if t.getProtocol() == SubprotocolGraphqlTransportWs {
+		interval = t.PingInterval
+	}
+
+	ka := newKeepAlive(t.KeepAliveTimeoutFactor, interval, func() {
+		if ctx.Err() == nil {
+			t.logEvent(LogLevelWarn, "ws keep alive timeout")
+			t.ResetWithErr(ErrWsKeepAliveTimeout)
+		}
+	})
+	ka.message(false)
+
+	go func() {
+		<-ctx.Done()
+		ka.stop()
+	}()
+
+	return ka
+}
+
+func (t *Ws) ping(ctx context.Context) {
+	ticker := time.NewTicker(t.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			msg := OperationMessage{
+				Type: GQL_PING,
+			}
+
+			t.logMessage("sent", msg)
+			if err := t.writeJson(msg); err != nil {
+				t.logEvent(LogLevelWarn, "ws ping failed", "error", err)
+				if ctx.Err() == nil {
+					t.ResetWithErr(err)
+				}
+				return
+			}
+		}
+	}
+}
+
+func (t *Ws) watchConnectionInit(ctx context.Context) {
+	timer := time.NewTimer(t.ConnectionInitTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+		if ctx.Err() == nil && t.getStatus() != StatusReady {
+			t.logEvent(LogLevelWarn, "ws connection init timeout")
+			t.ResetWithErr(ErrWsConnectionInitTimeout)
+		}
+	}
+}
+
 func (t *Ws) Reset() {
 	t.init()
 
@@ -385,46 +744,61 @@ func (t *Ws) ResetWithErr(err error) {
 	t.rm.Lock()
 	defer t.rm.Unlock()
 
-	if t.status == StatusDisconnected {
+	if err != nil {
+		t.lastErr = err
+	}
+
+	if t.getStatus() == StatusDisconnected {
 		return
 	}
 
 	t.setStatus(StatusDisconnected)
 
-	t.printLog(GQL_INTERNAL, "RESET", err)
+	t.logEvent(LogLevelInfo, "ws reset", "error", err)
+
+	t.Hooks.disconnect(err)
 
 	if err != nil {
 		t.sendErr(err)
 	}
 
+	t.opsm.Lock()
 	for id, op := range t.ops {
 		if op.started {
 			_ = t.stopOp(id)
 			op.started = false
 		}
 	}
+	t.opsm.Unlock()
 
 	_ = t.closeConn()
-
-	atomic.StoreUint64(&t.i, 0)
 }
 
-func (t *Ws) terminate() error {
+func (t *Ws) terminate(conn WebsocketConn) error {
 	msg := OperationMessage{
 		Type: GQL_CONNECTION_TERMINATE,
 	}
 
-	t.printLog(GQL_CONNECTION_TERMINATE, msg)
-	return t.writeJson(msg)
+	t.logMessage("sent", msg)
+	return conn.WriteJSON(msg)
 }
 
 func (t *Ws) closeConn() error {
-	_ = t.terminate()
-	err := t.conn.Close()
+	t.cm.Lock()
+	conn, protocol, cancel := t.conn, t.protocol, t.cancel
 	t.conn = &closedWs{}
-	t.cancel()
+	t.cm.Unlock()
+
+	if protocol != SubprotocolGraphqlTransportWs {
+		// graphql-transport-ws has no terminate message, closing the socket is enough
+		_ = t.terminate(conn)
+	}
+	err := conn.Close()
+	if cancel != nil {
+		cancel()
+	}
 
-	t.printLog(GQL_INTERNAL, "DONE CLOSE CONN", err)
+	t.logEvent(LogLevelDebug, "ws connection closed", "error", err)
 
 	return err
 }
@@ -432,105 +806,178 @@ func (t *Ws) closeConn() error {
 func (t *Ws) Close() error {
 	t.init()
 
-	t.printLog(GQL_INTERNAL, "CLOSE")
+	t.logEvent(LogLevelDebug, "ws close")
 
+	t.opsm.Lock()
+	ids := make([]string, 0, len(t.ops))
 	for id := range t.ops {
+		ids = append(ids, id)
+	}
+	t.opsm.Unlock()
+
+	for _, id := range ids {
 		_ = t.cancelOp(id)
 	}
 
+	if t.Lazy {
+		t.opsm.Lock()
+		cancel := t.lazyStop()
+		t.opsm.Unlock()
+
+		if cancel != nil {
+			cancel()
+		}
+	}
+
 	return t.closeConn()
 }
 
 func (t *Ws) Request(req Request) Response {
 	t.init()
 
-	t.printLog(GQL_INTERNAL, "REQ")
+	t.logEvent(LogLevelDebug, "ws request", "operation", req.Operation, "operationName", req.OperationName)
 
 	id := fmt.Sprintf("%v", atomic.AddUint64(&t.i, 1))
 
 	res := &wsResponse{
 		Context:          req.Context,
 		OperationRequest: NewOperationRequestFromRequest(req),
-		ChanResponse: NewChanResponse(
+		ChanResponse: NewBufferedChanResponse(
 			func() error {
-				t.printLog(GQL_INTERNAL, "CLOSE RES")
+				t.logEvent(LogLevelDebug, "ws close response", "id", id)
 				return t.cancelOp(id)
 			},
+			responseBufferFromContext(req.Context, t.ResponseBuffer),
 		),
 	}
+	res.resubscribe, res.resumable = resubscribeFromContext(req.Context)
 
-	t.printLog(GQL_INTERNAL, "ADD TO OPS")
+	t.logEvent(LogLevelDebug, "ws add operation", "id", id)
 	t.opsm.Lock()
+	if t.err != nil {
+		err := t.err
+		t.opsm.Unlock()
+		return NewErrorResponse(err)
+	}
 	t.ops[id] = res
-	t.opsm.Unlock()
+	if t.Lazy {
+		t.lazyStart()
+	}
 
-	if t.status == StatusReady {
+	if t.getStatus() == StatusReady {
 		err := t.startOp(id, res)
 		if err != nil {
+			delete(t.ops, id)
+			t.lazyIdle()
+			t.opsm.Unlock()
+
 			return NewErrorResponse(err)
 		}
 	}
+	t.opsm.Unlock()
 
 	return res
 }
 
-func (t *Ws) printLog(typ OperationMessageType, rest ...interface{}) {
-	if t.log {
-		fmt.Printf("# %-20v: ", typ)
-		fmt.Println(rest...)
+func (t *Ws) logEvent(level LogLevel, msg string, args ...interface{}) {
+	if t.logger != nil {
+		t.logger.Log(context.Background(), level, msg, args...)
 	}
 }
 
-func (t *Ws) registerOp(id string, op *wsResponse) {
-	t.opsm.Lock()
-	defer t.opsm.Unlock()
+// logMessage logs a protocol message at the debug level, the payload is not logged as it can hold
+// the connection params or the operations data
+func (t *Ws) logMessage(direction string, message OperationMessage, args ...interface{}) {
+	if t.logger == nil {
+		return
+	}
+
+	args = append([]interface{}{"direction", direction, "type", message.Type, "id", message.ID}, args...)
+	t.logger.Log(context.Background(), LogLevelDebug, "ws message", args...)
+}
 
-	t.ops[id] = op
+// connProtocol returns the protocol to speak over conn
+func (t *Ws) connProtocol(conn WebsocketConn) (WsSubprotocol, error) {
+	negotiated := ""
+	if sp, ok := conn.(WebsocketConnSubprotocol); ok {
+		negotiated = sp.Subprotocol()
+	} else if t.Subprotocol == "" {
+		t.logEvent(LogLevelWarn, "ws subprotocol not reported by the connection, assuming graphql-ws, set Subprotocol to choose it")
+	}
+
+	switch {
+	case negotiated == "":
+		if t.Subprotocol != "" {
+			return t.Subprotocol, nil
+		}
+		return SubprotocolGraphqlWs, nil
+	case negotiated != string(SubprotocolGraphqlWs) && negotiated != string(SubprotocolGraphqlTransportWs):
+		return "", fmt.Errorf("%w: unsupported %v", ErrWsSubprotocolMismatch, negotiated)
+	case t.Subprotocol != "" && negotiated != string(t.Subprotocol):
+		return "", fmt.Errorf("%w: negotiated %v, expected %v", ErrWsSubprotocolMismatch, negotiated, t.Subprotocol)
+	}
+
+	return WsSubprotocol(negotiated), nil
 }
 
+// startOp must be called with opsm held
 func (t *Ws) startOp(id string, op *wsResponse) error {
 	if op.started {
 		return nil
 	}
 
-	t.printLog(GQL_INTERNAL, "START OP")
+	t.logEvent(LogLevelDebug, "ws start operation", "id", id)
 
 	payload, err := json.Marshal(op.OperationRequest)
 	if err != nil {
 		return err
 	}
 
+	conn, protocol := t.getConn()
+
 	msg := OperationMessage{
 		ID:      id,
 		Type:    GQL_START,
 		Payload: payload,
 	}
+	if protocol == SubprotocolGraphqlTransportWs {
+		msg.Type = GQL_SUBSCRIBE
+	}
 
-	t.printLog(GQL_START, msg)
-	if err := t.writeJson(msg); err != nil {
-		t.printLog(GQL_INTERNAL, "GQL_START ERR", err)
+	if t.logger != nil {
+		// Redacting marshals the variables, only pay for it when logging
+		t.logMessage("sent", msg, "operationName", op.OperationRequest.OperationName, "variables", t.LogRedaction.RedactVariables(op.OperationRequest.Variables))
+	}
+	if err := conn.WriteJSON(msg); err != nil {
+		t.logEvent(LogLevelWarn, "ws start failed", "id", id, "error", err)
 		return err
 	}
 
 	op.started = true
+	op.startedOnce = true
 
 	return nil
 }
 
 func (t *Ws) stopOp(id string) error {
-	t.printLog(GQL_INTERNAL, "STOP OP", id)
+	t.logEvent(LogLevelDebug, "ws stop operation", "id", id)
+
+	conn, protocol := t.getConn()
 
 	msg := OperationMessage{
 		ID:   id,
 		Type: GQL_STOP,
 	}
+	if protocol == SubprotocolGraphqlTransportWs {
+		msg.Type = GQL_COMPLETE
+	}
 
-	t.printLog(GQL_STOP, msg)
-	return t.writeJson(msg)
+	t.logMessage("sent", msg)
+	return conn.WriteJSON(msg)
 }
 
 func (t *Ws) cancelOp(id string) error {
-	t.printLog(GQL_INTERNAL, "CANCEL OP", id)
+	t.logEvent(LogLevelDebug, "ws cancel operation", "id", id)
 
 	t.opsm.Lock()
 	op, ok := t.ops[id]
@@ -539,13 +986,103 @@ func (t *Ws) cancelOp(id string) error {
 		return nil
 	}
 	delete(t.ops, id)
+	started := op.started
+	t.lazyIdle()
 	t.opsm.Unlock()
 
 	op.CloseCh()
 
+	if !started {
+		return nil
+	}
+
 	return t.stopOp(id)
 }
 
+// completeOp removes an operation that has been completed by the server, no stop message is sent
+func (t *Ws) completeOp(id string) {
+	t.logEvent(LogLevelDebug, "ws complete operation", "id", id)
+
+	t.opsm.Lock()
+	op, ok := t.ops[id]
+	if !ok {
+		t.opsm.Unlock()
+		return
+	}
+	delete(t.ops, id)
+	t.lazyIdle()
+	t.opsm.Unlock()
+
+	op.CloseCh()
+}
+
+func (t *Ws) getOp(id string) (*wsResponse, bool) {
+	t.opsm.Lock()
+	defer t.opsm.Unlock()
+
+	op, ok := t.ops[id]
+
+	return op, ok
+}
+
+// dispatch routes an operation message (data, next, error, complete) to its operation
+func (t *Ws) dispatch(message OperationMessage) {
+	op, ok := t.getOp(message.ID)
+	if !ok {
+		atomic.AddUint64(&t.unknown, 1)
+		t.logEvent(LogLevelWarn, "ws message for unknown operation", "id", message.ID, "type", message.Type)
+		t.Hooks.unknownMessage(message)
+		return
+	}
+
+	switch message.Type {
+	case GQL_COMPLETE:
+		t.completeOp(message.ID)
+	case GQL_ERROR:
+		if t.getProtocol() == SubprotocolGraphqlTransportWs {
+			// The payload is a list of errors, and the operation is terminated
+			var out OperationResponse
+			err := json.Unmarshal(message.Payload, &out.Errors)
+			if err != nil {
+				out.Errors = append(out.Errors, gqlerror.WrapPath(nil, err))
+			}
+
+			op.Send(out)
+			t.completeOp(message.ID)
+			return
+		}
+
+		t.dispatchData(op, message)
+	default:
+		t.dispatchData(op, message)
+	}
+}
+
+func (t *Ws) dispatchData(op *wsResponse, message OperationMessage) {
+	var out OperationResponse
+	err := json.Unmarshal(message.Payload, &out)
+	if err != nil {
+		out.Errors = append(out.Errors, gqlerror.WrapPath(nil, err))
+	}
+	if op.resumable {
+		op.last = &out
+	}
+	op.Send(out)
+}
+
+// UnknownMessages returns the number of operation messages received for unknown operations
+func (t *Ws) UnknownMessages() uint64 {
+	return atomic.LoadUint64(&t.unknown)
+}
+
+// AckPayload returns the payload of the last connection ack received, nil if none
+func (t *Ws) AckPayload() json.RawMessage {
+	payload, _ := t.ack.Load().(json.RawMessage)
+	return payload
+}
+
 func (t *Ws) GetConn() WebsocketConn {
-	return t.conn
+	conn, _ := t.getConn()
+
+	return conn
 }
@@ -0,0 +1,242 @@
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// WsPool spreads operations over several Ws connections
+// Operations go to the ready connection with the fewest operations, when a connection resets its operations are moved
+// to the other ready connections (the ones that can't be moved are resubscribed once the connection is back)
+// Start() must be called to initiate the websocket connections
+// Close() must be called to dispose of the transport
+type WsPool struct {
+	// New creates the pool connections, the pool takes care of starting them and sets their Hooks.OnDisconnect
+	New func() *Ws
+	// Size is the number of connections, defaults to 1
+	Size int
+	// MaxOpsPerConn is the max number of operations per connection, requests fail with ErrWsPoolFull once
+	// all the connections are full. 0 means no limit
+	MaxOpsPerConn int
+
+	conns []*Ws
+	ops   map[*wsPoolOp]struct{}
+	count map[*Ws]int
+	m     sync.Mutex
+}
+
+type wsPoolOp struct {
+	req   Request
+	conn  *Ws
+	res   Response
+	proxy *ProxyResponse
+
+	// last is the last response received, for the ResubscribeFunc when the operation is moved
+	last *OperationResponse
+	lm   sync.Mutex
+}
+
+// bind forwards the responses of res, once marked is closed (if not nil)
+func (op *wsPoolOp) bind(res Response, marked <-chan struct{}) {
+	op.proxy.Bind(res, func(opres OperationResponse, send func()) {
+		if marked != nil {
+			<-marked
+		}
+
+		if !IsResubscribed(opres) {
+			op.lm.Lock()
+			op.last = &opres
+			op.lm.Unlock()
+		}
+
+		send()
+	})
+}
+
+func (p *WsPool) Start(ctx context.Context) <-chan error {
+	size := p.Size
+	if size <= 0 {
+		size = 1
+	}
+
+	p.m.Lock()
+	p.ops = make(map[*wsPoolOp]struct{})
+	p.count = make(map[*Ws]int)
+	p.conns = make([]*Ws, 0, size)
+	for i := 0; i < size; i++ {
+		conn := p.New()
+
+		onDisconnect := conn.Hooks.OnDisconnect
+		conn.Hooks.OnDisconnect = func(err error) {
+			if onDisconnect != nil {
+				onDisconnect(err)
+			}
+
+			go p.rebalance(conn)
+		}
+
+		p.conns = append(p.conns, conn)
+		p.count[conn] = 0
+	}
+	conns := p.conns
+	p.m.Unlock()
+
+	errCh := make(chan error)
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		if conn.Lazy {
+			continue
+		}
+
+		wg.Add(1)
+		go func(connErrCh <-chan error) {
+			defer wg.Done()
+
+			for err := range connErrCh {
+				select {
+				case errCh <- err: // Attempt to write err
+				default:
+				}
+			}
+		}(conn.Start(ctx))
+	}
+
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+
+	return errCh
+}
+
+// pick returns the connection with the fewest operations, ready connections first
+// Must be called with m held
+func (p *WsPool) pick(exclude *Ws, readyOnly bool) *Ws {
+	var best *Ws
+	var bestReady bool
+	for _, conn := range p.conns {
+		if conn == exclude {
+			continue
+		}
+
+		count := p.count[conn]
+		if p.MaxOpsPerConn > 0 && count >= p.MaxOpsPerConn {
+			continue
+		}
+
+		ready := conn.getStatus() == StatusReady
+		if readyOnly && !ready {
+			continue
+		}
+
+		if best == nil || (ready && !bestReady) || (ready == bestReady && count < p.count[best]) {
+			best = conn
+			bestReady = ready
+		}
+	}
+
+	return best
+}
+
+func (p *WsPool) Request(req Request) Response {
+	p.m.Lock()
+	conn := p.pick(nil, false)
+	if conn == nil {
+		p.m.Unlock()
+		return NewErrorResponse(ErrWsPoolFull)
+	}
+
+	op := &wsPoolOp{
+		req:   req,
+		conn:  conn,
+		proxy: NewProxyResponse(),
+	}
+	p.ops[op] = struct{}{}
+	p.count[conn]++
+
+	// Bind under the lock so that rebalance does not see the op half initialized
+	op.res = conn.Request(req)
+	op.bind(op.res, nil)
+	p.m.Unlock()
+
+	go func() {
+		<-op.proxy.Done()
+
+		p.m.Lock()
+		delete(p.ops, op)
+		p.count[op.conn]--
+		p.m.Unlock()
+	}()
+
+	return op.proxy
+}
+
+// rebalance moves the operations of conn to the other ready connections
+// The operations that opted into the resume semantics (see WithResubscribe) get their variables from the
+// ResubscribeFunc, and the resubscribed marker is delivered before their next responses
+func (p *WsPool) rebalance(conn *Ws) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for op := range p.ops {
+		if op.conn != conn {
+			continue
+		}
+
+		select {
+		case <-op.proxy.Done():
+			continue
+		default:
+		}
+
+		target := p.pick(conn, true)
+		if target == nil {
+			// Will be resubscribed when conn is back
+			return
+		}
+
+		conn.logEvent(LogLevelDebug, "ws pool rebalance operation")
+
+		var marked chan struct{}
+		if f, ok := resubscribeFromContext(op.req.Context); ok {
+			if f != nil {
+				op.lm.Lock()
+				last := op.last
+				op.lm.Unlock()
+
+				op.req.Variables = f(op.req.Variables, last)
+			}
+
+			marked = make(chan struct{})
+			go func(op *wsPoolOp, marked chan struct{}) {
+				op.proxy.Send(NewResubscribedOperationResponse())
+				close(marked)
+			}(op, marked)
+		}
+
+		prev := op.res
+		op.res = target.Request(op.req)
+		op.bind(op.res, marked)
+		op.proxy.Unbind(prev)
+		go prev.Close()
+
+		p.count[conn]--
+		p.count[target]++
+		op.conn = target
+	}
+}
+
+func (p *WsPool) Close() error {
+	p.m.Lock()
+	conns := p.conns
+	p.m.Unlock()
+
+	var err error
+	for _, conn := range conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,846 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"nhooyr.io/websocket"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeWsConn is an in memory WebsocketConn, the test plays the server through in and out
+type fakeWsConn struct {
+	subprotocol string
+	in          chan OperationMessage
+	out         chan OperationMessage
+	errs        chan error
+	closed      chan struct{}
+	o           sync.Once
+}
+
+func newFakeWsConn(subprotocol string) *fakeWsConn {
+	return &fakeWsConn{
+		subprotocol: subprotocol,
+		in:          make(chan OperationMessage, 100),
+		out:         make(chan OperationMessage, 100),
+		errs:        make(chan error, 1),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (c *fakeWsConn) ReadJSON(v interface{}) error {
+	select {
+	case msg := <-c.in:
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(b, v)
+	case err := <-c.errs:
+		return err
+	case <-c.closed:
+		return io.EOF
+	}
+}
+
+func (c *fakeWsConn) WriteJSON(v interface{}) error {
+	select {
+	case <-c.closed:
+		return ErrClosedConnection
+	default:
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var msg OperationMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return err
+	}
+
+	select {
+	case c.out <- msg:
+		return nil
+	case <-c.closed:
+		return ErrClosedConnection
+	}
+}
+
+func (c *fakeWsConn) Close() error {
+	c.o.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *fakeWsConn) SetReadLimit(limit int64) {}
+
+func (c *fakeWsConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// send plays a server message
+func (c *fakeWsConn) send(id string, typ OperationMessageType, payload string) {
+	msg := OperationMessage{
+		ID:   id,
+		Type: typ,
+	}
+	if payload != "" {
+		msg.Payload = json.RawMessage(payload)
+	}
+
+	c.in <- msg
+}
+
+// closeWith plays the server closing the connection with code
+func (c *fakeWsConn) closeWith(code websocket.StatusCode, reason string) {
+	c.errs <- websocket.CloseError{Code: code, Reason: reason}
+}
+
+// expect waits for a client message of type typ, skipping the others
+func (c *fakeWsConn) expect(t *testing.T, typ OperationMessageType) OperationMessage {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-c.out:
+			if msg.Type == typ {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %v", typ)
+		}
+	}
+}
+
+type fakeWsServer struct {
+	subprotocol string
+	conns       chan *fakeWsConn
+}
+
+func newFakeWsServer(subprotocol string) *fakeWsServer {
+	return &fakeWsServer{
+		subprotocol: subprotocol,
+		conns:       make(chan *fakeWsConn, 10),
+	}
+}
+
+func (s *fakeWsServer) provider(ctx context.Context, URL string) (WebsocketConn, error) {
+	c := newFakeWsConn(s.subprotocol)
+	s.conns <- c
+	return c, nil
+}
+
+// accept waits for a new connection, and acks it
+func (s *fakeWsServer) accept(t *testing.T) *fakeWsConn {
+	t.Helper()
+
+	select {
+	case c := <-s.conns:
+		c.expect(t, GQL_CONNECTION_INIT)
+		c.send("", GQL_CONNECTION_ACK, "")
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for conn")
+	}
+
+	return nil
+}
+
+func nextResponse(t *testing.T, res Response) OperationResponse {
+	t.Helper()
+
+	if !res.Next() {
+		t.Fatalf("expected a response, got err: %v", res.Err())
+	}
+
+	return res.Get()
+}
+
+func TestWsResubscribe(t *testing.T) {
+	for _, sp := range []WsSubprotocol{SubprotocolGraphqlWs, SubprotocolGraphqlTransportWs} {
+		t.Run(string(sp), func(t *testing.T) {
+			startType, dataType := GQL_START, GQL_DATA
+			if sp == SubprotocolGraphqlTransportWs {
+				startType, dataType = GQL_SUBSCRIBE, GQL_NEXT
+			}
+
+			srv := newFakeWsServer(string(sp))
+
+			tr := &Ws{
+				URL:                   "ws://fake",
+				WebsocketConnProvider: srv.provider,
+			}
+			tr.Start(context.Background())
+			defer tr.Close()
+
+			c1 := srv.accept(t)
+			tr.waitFor(StatusReady)
+
+			var lastCalled *OperationResponse
+			ctx := WithResubscribe(context.Background(), func(variables map[string]interface{}, last *OperationResponse) map[string]interface{} {
+				lastCalled = last
+
+				var data struct {
+					ID string `json:"id"`
+				}
+				_ = last.UnmarshalData(&data)
+
+				return map[string]interface{}{"since": data.ID}
+			})
+
+			res := tr.Request(Request{
+				Context:   ctx,
+				Operation: Subscription,
+				Query:     "subscription { messages }",
+				Variables: map[string]interface{}{"since": ""},
+			})
+			defer res.Close()
+
+			start := c1.expect(t, startType)
+			c1.send(start.ID, dataType, `{"data":{"id":"msg0"}}`)
+
+			opres := nextResponse(t, res)
+			assert.False(t, IsResubscribed(opres))
+			assert.JSONEq(t, `{"id":"msg0"}`, string(opres.Data))
+
+			// Drop the connection
+			_ = c1.Close()
+
+			c2 := srv.accept(t)
+			start = c2.expect(t, startType)
+
+			var oreq OperationRequest
+			err := json.Unmarshal(start.Payload, &oreq)
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"since": "msg0"}, oreq.Variables)
+			assert.NotNil(t, lastCalled)
+
+			opres = nextResponse(t, res)
+			assert.True(t, IsResubscribed(opres))
+
+			c2.send(start.ID, dataType, `{"data":{"id":"msg1"}}`)
+
+			opres = nextResponse(t, res)
+			assert.False(t, IsResubscribed(opres))
+			assert.JSONEq(t, `{"id":"msg1"}`, string(opres.Data))
+		})
+	}
+}
+
+func TestWsNoResubscribeMarkerByDefault(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlWs))
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+	}
+	tr.Start(context.Background())
+	defer tr.Close()
+
+	c1 := srv.accept(t)
+	tr.waitFor(StatusReady)
+
+	res := tr.Request(Request{
+		Context:   context.Background(),
+		Operation: Subscription,
+		Query:     "subscription { messages }",
+	})
+	defer res.Close()
+
+	c1.expect(t, GQL_START)
+	_ = c1.Close()
+
+	c2 := srv.accept(t)
+	start := c2.expect(t, GQL_START)
+	c2.send(start.ID, GQL_DATA, `{"data":{"id":"msg0"}}`)
+
+	opres := nextResponse(t, res)
+	assert.JSONEq(t, `{"id":"msg0"}`, string(opres.Data))
+}
+
+func (c *fakeWsConn) expectClosed(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-c.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for conn close")
+	}
+}
+
+func TestWsLazy(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+		Lazy:                  true,
+		IdleTimeout:           50 * time.Millisecond,
+	}
+	defer tr.Close()
+
+	select {
+	case <-srv.conns:
+		t.Fatal("should not connect before the first request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for i := 0; i < 2; i++ {
+		res := tr.Request(Request{
+			Context:   context.Background(),
+			Operation: Subscription,
+			Query:     "subscription { messages }",
+		})
+
+		c := srv.accept(t)
+		start := c.expect(t, GQL_SUBSCRIBE)
+		c.send(start.ID, GQL_NEXT, `{"data":{"id":"msg0"}}`)
+		c.send(start.ID, GQL_COMPLETE, "")
+
+		opres := nextResponse(t, res)
+		assert.JSONEq(t, `{"id":"msg0"}`, string(opres.Data))
+		assert.False(t, res.Next())
+
+		c.expectClosed(t)
+	}
+}
+
+func TestWsLazyKeepsConnWhileBusy(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+		Lazy:                  true,
+		IdleTimeout:           50 * time.Millisecond,
+	}
+	defer tr.Close()
+
+	res1 := tr.Request(Request{
+		Context:   context.Background(),
+		Operation: Subscription,
+		Query:     "subscription { messages }",
+	})
+	c := srv.accept(t)
+	c.expect(t, GQL_SUBSCRIBE)
+
+	res2 := tr.Request(Request{
+		Context:   context.Background(),
+		Operation: Subscription,
+		Query:     "subscription { messages }",
+	})
+	c.expect(t, GQL_SUBSCRIBE)
+
+	res1.Close()
+
+	select {
+	case <-c.closed:
+		t.Fatal("should not close while an operation is running")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	res2.Close()
+
+	c.expectClosed(t)
+}
+
+func newFakeWsPool(srv *fakeWsServer, size, maxOpsPerConn int) *WsPool {
+	return &WsPool{
+		New: func() *Ws {
+			return &Ws{
+				URL:                   "ws://fake",
+				WebsocketConnProvider: srv.provider,
+			}
+		},
+		Size:          size,
+		MaxOpsPerConn: maxOpsPerConn,
+	}
+}
+
+func TestWsPoolSpread(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	pool := newFakeWsPool(srv, 2, 1)
+	pool.Start(context.Background())
+	defer pool.Close()
+
+	c1 := srv.accept(t)
+	c2 := srv.accept(t)
+	for _, conn := range pool.conns {
+		conn.waitFor(StatusReady)
+	}
+
+	res1 := pool.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { a }"})
+	defer res1.Close()
+	res2 := pool.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { b }"})
+	defer res2.Close()
+
+	// One operation per connection
+	c1.expect(t, GQL_SUBSCRIBE)
+	c2.expect(t, GQL_SUBSCRIBE)
+
+	res3 := pool.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { c }"})
+	assert.False(t, res3.Next())
+	assert.Equal(t, ErrWsPoolFull, res3.Err())
+
+	res1.Close()
+	<-res1.Done()
+
+	assert.Eventually(t, func() bool {
+		res4 := pool.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { d }"})
+		defer res4.Close()
+
+		return res4.Err() == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWsPoolRebalance(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	pool := newFakeWsPool(srv, 2, 0)
+	pool.Start(context.Background())
+	defer pool.Close()
+
+	c1 := srv.accept(t)
+	c2 := srv.accept(t)
+	for _, conn := range pool.conns {
+		conn.waitFor(StatusReady)
+	}
+
+	// The moved operation resumes from the last id it received
+	ctx := WithResubscribe(context.Background(), func(variables map[string]interface{}, last *OperationResponse) map[string]interface{} {
+		var data struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(last.Data, &data)
+
+		return map[string]interface{}{"since": data.ID}
+	})
+
+	resA := pool.Request(Request{Context: ctx, Operation: Subscription, Query: "subscription { a }"})
+	defer resA.Close()
+	resB := pool.Request(Request{Context: ctx, Operation: Subscription, Query: "subscription { b }"})
+	defer resB.Close()
+
+	var oreq OperationRequest
+	start := c1.expect(t, GQL_SUBSCRIBE)
+	_ = json.Unmarshal(start.Payload, &oreq)
+	c2.expect(t, GQL_SUBSCRIBE)
+
+	moved := resA
+	if oreq.Query == "subscription { b }" {
+		moved = resB
+	}
+
+	c1.send(start.ID, GQL_NEXT, `{"data":{"id":"1"}}`)
+	opres := nextResponse(t, moved)
+	assert.JSONEq(t, `{"id":"1"}`, string(opres.Data))
+
+	// Drop c1, the reconnection is never acked so its operation has to move to c2
+	_ = c1.Close()
+
+	start = c2.expect(t, GQL_SUBSCRIBE)
+	var moreq OperationRequest
+	_ = json.Unmarshal(start.Payload, &moreq)
+	assert.Equal(t, oreq.Query, moreq.Query)
+	assert.Equal(t, map[string]interface{}{"since": "1"}, moreq.Variables)
+
+	c2.send(start.ID, GQL_NEXT, `{"data":{"id":"moved"}}`)
+
+	assert.True(t, IsResubscribed(nextResponse(t, moved)))
+
+	opres = nextResponse(t, moved)
+	assert.JSONEq(t, `{"id":"moved"}`, string(opres.Data))
+}
+
+func TestWsConnectionParamsAndAck(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlWs))
+
+	var i int32
+	disconnectErrs := make(chan error, 10)
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+		ReconnectBackoff:      ConstantBackoff(10 * time.Millisecond),
+		ConnectionParamsProvider: func(ctx context.Context) (interface{}, error) {
+			return map[string]interface{}{"token": atomic.AddInt32(&i, 1)}, nil
+		},
+		Hooks: WsHooks{
+			OnDisconnect: func(err error) {
+				disconnectErrs <- err
+			},
+		},
+	}
+	tr.Start(context.Background())
+	defer tr.Close()
+
+	// Rejected
+	c := <-srv.conns
+	init := c.expect(t, GQL_CONNECTION_INIT)
+	assert.JSONEq(t, `{"token":1}`, string(init.Payload))
+	c.send("", GQL_CONNECTION_ERROR, `{"message":"token expired"}`)
+
+	err := <-disconnectErrs
+	var cerr *WsConnectionError
+	if assert.True(t, errors.As(err, &cerr)) {
+		var payload struct {
+			Message string `json:"message"`
+		}
+		assert.NoError(t, cerr.Unmarshal(&payload))
+		assert.Equal(t, "token expired", payload.Message)
+	}
+	c.expectClosed(t)
+
+	// Accepted with fresh params
+	c = <-srv.conns
+	init = c.expect(t, GQL_CONNECTION_INIT)
+	assert.JSONEq(t, `{"token":2}`, string(init.Payload))
+	c.send("", GQL_CONNECTION_ACK, `{"session":"abc"}`)
+
+	tr.waitFor(StatusReady)
+	assert.JSONEq(t, `{"session":"abc"}`, string(tr.AckPayload()))
+}
+
+func TestWsSlowConsumerDoesNotStall(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+		ResponseBuffer: ResponseBuffer{
+			Size:     1,
+			Overflow: OverflowDropOldest,
+		},
+	}
+	tr.Start(context.Background())
+	defer tr.Close()
+
+	c := srv.accept(t)
+	tr.waitFor(StatusReady)
+
+	slow := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { slow }"})
+	defer slow.Close()
+	slowStart := c.expect(t, GQL_SUBSCRIBE)
+
+	fast := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { fast }"})
+	defer fast.Close()
+	fastStart := c.expect(t, GQL_SUBSCRIBE)
+
+	// Nobody reads slow
+	for i := 0; i < 5; i++ {
+		c.send(slowStart.ID, GQL_NEXT, fmt.Sprintf(`{"data":"slow%v"}`, i))
+	}
+
+	for i := 0; i < 3; i++ {
+		c.send(fastStart.ID, GQL_NEXT, fmt.Sprintf(`{"data":"fast%v"}`, i))
+
+		opres := nextResponse(t, fast)
+		assert.JSONEq(t, fmt.Sprintf(`"fast%v"`, i), string(opres.Data))
+	}
+
+	opres := nextResponse(t, slow)
+	assert.JSONEq(t, `"slow4"`, string(opres.Data))
+}
+
+func TestWsUnknownOperation(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	unknown := make(chan OperationMessage, 10)
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+		Hooks: WsHooks{
+			OnUnknownMessage: func(message OperationMessage) {
+				unknown <- message
+			},
+		},
+	}
+	tr.Start(context.Background())
+	defer tr.Close()
+
+	c := srv.accept(t)
+	tr.waitFor(StatusReady)
+
+	c.send("unknown", GQL_NEXT, `{"data":"lost"}`)
+
+	select {
+	case msg := <-unknown:
+		assert.Equal(t, "unknown", msg.ID)
+		assert.Equal(t, GQL_NEXT, msg.Type)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for unknown message")
+	}
+	assert.Equal(t, uint64(1), tr.UnknownMessages())
+
+	// The transport must still be usable
+	res := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { messages }"})
+	defer res.Close()
+
+	start := c.expect(t, GQL_SUBSCRIBE)
+	c.send(start.ID, GQL_NEXT, `{"data":"msg0"}`)
+
+	opres := nextResponse(t, res)
+	assert.JSONEq(t, `"msg0"`, string(opres.Data))
+}
+
+func TestWsLateMessageAfterStop(t *testing.T) {
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+	}
+	tr.Start(context.Background())
+	defer tr.Close()
+
+	c := srv.accept(t)
+	tr.waitFor(StatusReady)
+
+	res := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { messages }"})
+	start := c.expect(t, GQL_SUBSCRIBE)
+
+	res.Close()
+	stop := c.expect(t, GQL_COMPLETE)
+	assert.Equal(t, start.ID, stop.ID)
+
+	// The server had not seen the complete yet
+	c.send(start.ID, GQL_NEXT, `{"data":"late"}`)
+	c.send(start.ID, GQL_COMPLETE, "")
+
+	assert.Eventually(t, func() bool {
+		return tr.UnknownMessages() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// Ids are never reused, even across reconnections
+	_ = c.Close()
+	c = srv.accept(t)
+	tr.waitFor(StatusReady)
+
+	res = tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { messages }"})
+	defer res.Close()
+
+	next := c.expect(t, GQL_SUBSCRIBE)
+	assert.NotEqual(t, start.ID, next.ID)
+}
+
+// TestWsDispatchStress mixes concurrent requests and closes with random server messages and connection drops,
+// it is mostly useful with -race
+func TestWsDispatchStress(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %v", seed)
+	rnd := rand.New(rand.NewSource(seed))
+	var rndm sync.Mutex
+	intn := func(n int) int {
+		rndm.Lock()
+		defer rndm.Unlock()
+
+		return rnd.Intn(n)
+	}
+
+	srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+
+	tr := &Ws{
+		URL:                   "ws://fake",
+		WebsocketConnProvider: srv.provider,
+		ReconnectBackoff:      ConstantBackoff(time.Millisecond),
+	}
+	tr.Start(context.Background())
+
+	serverDone := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case c := <-srv.conns:
+				go func(c *fakeWsConn) {
+					var ids []string
+					for {
+						var msg OperationMessage
+						select {
+						case msg = <-c.out:
+						case <-c.closed:
+							return
+						case <-serverDone:
+							return
+						}
+
+						switch msg.Type {
+						case GQL_CONNECTION_INIT:
+							c.send("", GQL_CONNECTION_ACK, "")
+							continue
+						case GQL_SUBSCRIBE:
+							ids = append(ids, msg.ID)
+						}
+
+						if len(ids) == 0 {
+							continue
+						}
+
+						id := ids[intn(len(ids))]
+						switch intn(6) {
+						case 0:
+							c.send(id, GQL_COMPLETE, "")
+						case 1:
+							c.send(id, GQL_ERROR, `[{"message":"boom"}]`)
+						case 2:
+							c.send("unknown", GQL_NEXT, `{"data":"lost"}`)
+						case 3:
+							if intn(10) == 0 {
+								_ = c.Close()
+								return
+							}
+						default:
+							c.send(id, GQL_NEXT, `{"data":"msg"}`)
+						}
+					}
+				}(c)
+			case <-serverDone:
+				return
+			}
+		}
+	}()
+	defer close(serverDone)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 25; j++ {
+				res := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { messages }"})
+
+				consumed := make(chan struct{})
+				go func() {
+					defer close(consumed)
+					for res.Next() {
+						_ = res.Get()
+					}
+				}()
+
+				time.Sleep(time.Duration(intn(2000)) * time.Microsecond)
+				if intn(10) == 0 {
+					tr.Reset()
+				}
+
+				res.Close()
+				<-consumed
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		_ = tr.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("deadlock")
+	}
+}
+
+func TestWsSubprotocol(t *testing.T) {
+	t.Run("not negotiated", func(t *testing.T) {
+		srv := newFakeWsServer("")
+
+		tr := &Ws{
+			URL:                   "ws://fake",
+			WebsocketConnProvider: srv.provider,
+			Subprotocol:           SubprotocolGraphqlTransportWs,
+		}
+		tr.Start(context.Background())
+		defer tr.Close()
+
+		c := srv.accept(t)
+		tr.waitFor(StatusReady)
+
+		res := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { a }"})
+		defer res.Close()
+
+		c.expect(t, GQL_SUBSCRIBE)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		srv := newFakeWsServer(string(SubprotocolGraphqlWs))
+
+		tr := &Ws{
+			URL:                   "ws://fake",
+			WebsocketConnProvider: srv.provider,
+			Subprotocol:           SubprotocolGraphqlTransportWs,
+			ReconnectBackoff:      &ExponentialBackoff{InitialInterval: 10 * time.Millisecond, MaxAttempts: 1},
+		}
+		tr.Start(context.Background())
+		defer tr.Close()
+
+		res := tr.Request(Request{Context: context.Background(), Operation: Subscription, Query: "subscription { a }"})
+		defer res.Close()
+
+		c := <-srv.conns
+		c.expectClosed(t)
+
+		assert.False(t, res.Next())
+		assert.True(t, errors.Is(res.Err(), ErrWsReconnectAborted))
+		assert.Contains(t, res.Err().Error(), "websocket subprotocol mismatch: negotiated graphql-ws, expected graphql-transport-ws")
+	})
+}
+
+func TestWsCloseErrors(t *testing.T) {
+	for _, target := range []*WsCloseError{ErrWsInvalidMessage, ErrWsUnauthorized, ErrWsSubscriberAlreadyExists, ErrWsTooManyInitRequests} {
+		t.Run(target.Reason, func(t *testing.T) {
+			srv := newFakeWsServer(string(SubprotocolGraphqlTransportWs))
+			disconnectErrs := make(chan error, 10)
+
+			tr := &Ws{
+				URL:                   "ws://fake",
+				WebsocketConnProvider: srv.provider,
+				ReconnectBackoff:      ConstantBackoff(10 * time.Millisecond),
+				Hooks: WsHooks{
+					OnDisconnect: func(err error) {
+						disconnectErrs <- err
+					},
+				},
+			}
+			tr.Start(context.Background())
+			defer tr.Close()
+
+			c := srv.accept(t)
+			tr.waitFor(StatusReady)
+
+			c.closeWith(target.Code, "")
+
+			err := <-disconnectErrs
+			assert.True(t, errors.Is(err, target))
+
+			var cerr *WsCloseError
+			if assert.True(t, errors.As(err, &cerr)) {
+				// The reason defaults to the protocol one
+				assert.Equal(t, target.Reason, cerr.Reason)
+			}
+
+			// Reconnects
+			srv.accept(t)
+			tr.waitFor(StatusReady)
+		})
+	}
+
+	// An unknown code is not a WsCloseError
+	assert.Nil(t, toWsCloseError(websocket.CloseError{Code: 4999}))
+	assert.Equal(t, &WsCloseError{Code: 4401, Reason: "token expired"}, toWsCloseError(fmt.Errorf("read: %w", websocket.CloseError{Code: 4401, Reason: "token expired"})))
+}
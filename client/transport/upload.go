@@ -3,19 +3,110 @@ package transport
 import (
 	"fmt"
 	"io"
+	"mime"
 	"os"
+	"path/filepath"
+	"reflect"
 )
 
+// NewUpload creates an Upload from f, with its size and its content type guessed from its extension
 func NewUpload(f *os.File) Upload {
-	return Upload{
-		File: f,
-		Name: f.Name(),
+	up := Upload{
+		File:        f,
+		Name:        f.Name(),
+		ContentType: mime.TypeByExtension(filepath.Ext(f.Name())),
 	}
+
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		up.Size = fi.Size()
+	}
+
+	return up
 }
 
 type Upload struct {
 	Name string
+	// File is read from its start when it is an io.ReaderAt (with a Size) or an io.Seeker, so that the upload can be
+	// sent more than once (ie: on retry), see Replayable
 	File io.Reader
+	// ContentType of the file part, defaults to application/octet-stream
+	ContentType string
+	// Size is the exact size of File, 0 if unknown. When the size of all the uploads of a request is known, the
+	// request is sent with a Content-Length
+	Size int64
+	// Progress is called as the file is sent, with the number of bytes sent so far
+	Progress func(sent int64)
+}
+
+// Replayable returns true if the upload can be read again from its start
+func (u Upload) Replayable() bool {
+	if _, ok := u.File.(io.ReaderAt); ok && u.Size > 0 {
+		return true
+	}
+
+	_, ok := u.File.(io.Seeker)
+
+	return ok
+}
+
+// CollectUploads returns the uploads found in the variables of a request, by path (ie: variables.files.0)
+func CollectUploads(variables map[string]interface{}) map[string]Upload {
+	var h Http
+
+	return h.collectUploads("variables", variables)
+}
+
+func (u Upload) contentType() string {
+	if u.ContentType == "" {
+		return "application/octet-stream"
+	}
+
+	return u.ContentType
+}
+
+// key identifies the uploads sharing the same File, nil if File can't be compared
+func (u Upload) key() interface{} {
+	if u.File == nil || !reflect.TypeOf(u.File).Comparable() {
+		return nil
+	}
+
+	return u.File
+}
+
+func (u Upload) reader() (io.Reader, error) {
+	var r io.Reader
+	if ra, ok := u.File.(io.ReaderAt); ok && u.Size > 0 {
+		r = io.NewSectionReader(ra, 0, u.Size)
+	} else if s, ok := u.File.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		r = u.File
+	} else {
+		r = u.File
+	}
+
+	if u.Progress == nil {
+		return r, nil
+	}
+
+	return &progressReader{r: r, progress: u.Progress}, nil
+}
+
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	progress func(sent int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.progress(r.sent)
+	}
+
+	return n, err
 }
 
 func (u Upload) MarshalJSON() ([]byte, error) {
@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nhooyr.io/websocket"
+)
+
+// ErrWsReconnectAborted is returned once the ReconnectBackoff gave up reconnecting
+var ErrWsReconnectAborted = errors.New("websocket reconnect aborted")
+
+// ErrWsKeepAliveTimeout is returned when the server missed too many keep alives
+var ErrWsKeepAliveTimeout = errors.New("websocket keep alive timeout")
+
+// ErrWsSubprotocolMismatch is returned when the connection negotiated another subprotocol than Ws.Subprotocol,
+// or one that is not supported
+var ErrWsSubprotocolMismatch = errors.New("websocket subprotocol mismatch")
+
+// ErrWsPoolFull is returned when all the WsPool connections reached MaxOpsPerConn
+var ErrWsPoolFull = errors.New("websocket pool full")
+
+// WsConnectionError is returned when the server rejects the connection init (graphql-ws only,
+// graphql-transport-ws servers close the connection with ErrWsForbidden instead)
+type WsConnectionError struct {
+	Payload json.RawMessage
+}
+
+func (e *WsConnectionError) Error() string {
+	return fmt.Sprintf("gql conn error: %s", e.Payload)
+}
+
+// Unmarshal decodes the payload sent by the server into v
+func (e *WsConnectionError) Unmarshal(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// WsCloseError is returned when the server closes the connection with one of the
+// graphql-transport-ws close codes, use errors.Is to compare it against the ErrWs* values
+type WsCloseError struct {
+	Code   websocket.StatusCode
+	Reason string
+}
+
+func (e *WsCloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %v %v", int(e.Code), e.Reason)
+}
+
+func (e *WsCloseError) Is(target error) bool {
+	t, ok := target.(*WsCloseError)
+	if !ok {
+		return false
+	}
+
+	return t.Code == e.Code
+}
+
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+var (
+	ErrWsInvalidMessage          = &WsCloseError{Code: 4400, Reason: "invalid message"}
+	ErrWsUnauthorized            = &WsCloseError{Code: 4401, Reason: "unauthorized"}
+	ErrWsForbidden               = &WsCloseError{Code: 4403, Reason: "forbidden"}
+	ErrWsConnectionInitTimeout   = &WsCloseError{Code: 4408, Reason: "connection initialisation timeout"}
+	ErrWsSubscriberAlreadyExists = &WsCloseError{Code: 4409, Reason: "subscriber already exists"}
+	ErrWsTooManyInitRequests     = &WsCloseError{Code: 4429, Reason: "too many initialisation requests"}
+)
+
+var wsCloseErrors = []*WsCloseError{
+	ErrWsInvalidMessage,
+	ErrWsUnauthorized,
+	ErrWsForbidden,
+	ErrWsConnectionInitTimeout,
+	ErrWsSubscriberAlreadyExists,
+	ErrWsTooManyInitRequests,
+}
+
+// toWsCloseError returns a *WsCloseError if err is a close frame with a known code, nil otherwise
+func toWsCloseError(err error) error {
+	var cerr websocket.CloseError
+	if !errors.As(err, &cerr) {
+		return nil
+	}
+
+	for _, e := range wsCloseErrors {
+		if e.Code == cerr.Code {
+			reason := cerr.Reason
+			if reason == "" {
+				reason = e.Reason
+			}
+
+			return &WsCloseError{Code: cerr.Code, Reason: reason}
+		}
+	}
+
+	return nil
+}
@@ -40,10 +40,14 @@ type WebsocketHandler struct {
 }
 
 func (wh *WebsocketHandler) WriteJSON(v interface{}) error {
-	ctx, cancel := context.WithTimeout(wh.ctx, wh.timeout)
-	defer cancel()
+	if wh.timeout > 0 {
+		ctx, cancel := context.WithTimeout(wh.ctx, wh.timeout)
+		defer cancel()
+
+		return wsjson.Write(ctx, wh.Conn, v)
+	}
 
-	return wsjson.Write(ctx, wh.Conn, v)
+	return wsjson.Write(wh.ctx, wh.Conn, v)
 }
 
 func (wh *WebsocketHandler) ReadJSON(v interface{}) error {
@@ -67,10 +71,11 @@ type WsDialOption func(o *websocket.DialOptions)
 // A timeout of 0 means no timeout, reading will block until a message is received or the context is canceled
 // If your server supports the keepalive, set the timeout to something greater than the server keepalive
 // (for example 15s for a 10s keepalive)
+// Both graphql-ws and graphql-transport-ws subprotocols are offered, the server picks the one it prefers
 func DefaultWebsocketConnProvider(timeout time.Duration, optionfs ...WsDialOption) WebsocketConnProvider {
 	return func(ctx context.Context, URL string) (WebsocketConn, error) {
 		options := &websocket.DialOptions{
-			Subprotocols: []string{"graphql-ws"},
+			Subprotocols: []string{string(SubprotocolGraphqlWs), string(SubprotocolGraphqlTransportWs)},
 		}
 		for _, f := range optionfs {
 			f(options)
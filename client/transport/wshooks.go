@@ -0,0 +1,57 @@
+package transport
+
+import "encoding/json"
+
+// WsHooks are notified of the Ws connection lifecycle
+// Hooks are called synchronously from the transport goroutines, they must not block
+type WsHooks struct {
+	// OnConnect is called once the websocket connection is established, before the connection init is sent
+	OnConnect func()
+	// OnAck is called when the server acknowledges the connection, with the ack payload (which may be nil)
+	OnAck func(payload json.RawMessage)
+	// OnKeepAlive is called when a keep alive (graphql-ws) or a ping/pong (graphql-transport-ws) is received
+	OnKeepAlive func()
+	// OnDisconnect is called when an established connection is lost, err is the cause (nil on normal closure)
+	OnDisconnect func(err error)
+	// OnReconnect is called before each reconnection attempt, attempt is the number of attempts since the connection was last ready
+	OnReconnect func(attempt int)
+	// OnUnknownMessage is called when the server sends a message for an operation the transport doesn't know about,
+	// typically a late message for an operation that has just been stopped
+	OnUnknownMessage func(message OperationMessage)
+}
+
+func (h WsHooks) connect() {
+	if h.OnConnect != nil {
+		h.OnConnect()
+	}
+}
+
+func (h WsHooks) ack(payload json.RawMessage) {
+	if h.OnAck != nil {
+		h.OnAck(payload)
+	}
+}
+
+func (h WsHooks) keepAlive() {
+	if h.OnKeepAlive != nil {
+		h.OnKeepAlive()
+	}
+}
+
+func (h WsHooks) disconnect(err error) {
+	if h.OnDisconnect != nil {
+		h.OnDisconnect(err)
+	}
+}
+
+func (h WsHooks) reconnect(attempt int) {
+	if h.OnReconnect != nil {
+		h.OnReconnect(attempt)
+	}
+}
+
+func (h WsHooks) unknownMessage(message OperationMessage) {
+	if h.OnUnknownMessage != nil {
+		h.OnUnknownMessage(message)
+	}
+}
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// keepAlive detects dead connections, onDead is called when no message has been received
+// for factor times the keep alive interval.
+// The interval is either fixed, or measured between the received keep alives (the longest one is kept)
+type keepAlive struct {
+	m        sync.Mutex
+	factor   int
+	interval time.Duration
+	fixed    bool
+	lastKa   time.Time
+	timer    *time.Timer
+	stopped  bool
+	onDead   func()
+}
+
+func newKeepAlive(factor int, interval time.Duration, onDead func()) *keepAlive {
+	return &keepAlive{
+		factor:   factor,
+		interval: interval,
+		fixed:    interval > 0,
+		onDead:   onDead,
+	}
+}
+
+// message must be called for every message received, ka being true for keep alive messages
+func (k *keepAlive) message(ka bool) {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	if k.stopped {
+		return
+	}
+
+	if ka && !k.fixed {
+		now := time.Now()
+		if !k.lastKa.IsZero() {
+			if d := now.Sub(k.lastKa); d > k.interval {
+				k.interval = d
+			}
+		}
+		k.lastKa = now
+	}
+
+	if k.interval == 0 {
+		return
+	}
+
+	d := time.Duration(k.factor) * k.interval
+	if k.timer == nil {
+		k.timer = time.AfterFunc(d, k.onDead)
+	} else {
+		k.timer.Reset(d)
+	}
+}
+
+func (k *keepAlive) stop() {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	k.stopped = true
+	if k.timer != nil {
+		k.timer.Stop()
+	}
+}
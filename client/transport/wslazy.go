@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// lazyStart starts the connection if it is not running, and cancels the pending idle close
+// Must be called with opsm held
+func (t *Ws) lazyStart() {
+	if t.idleTimer != nil {
+		t.idleTimer.Stop()
+		t.idleTimer = nil
+	}
+
+	if t.lazyCancel != nil {
+		return
+	}
+
+	t.logEvent(LogLevelDebug, "ws lazy start")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.lazyCancel = cancel
+
+	prev := t.lazyDone
+	done := make(chan struct{})
+	t.lazyDone = done
+
+	go func() {
+		defer close(done)
+
+		if prev != nil {
+			// Wait for the previous connection to be fully stopped
+			<-prev
+		}
+
+		t.openErrCh()
+		t.run(ctx)
+	}()
+}
+
+// lazyIdle schedules the idle close when there are no more operations
+// Must be called with opsm held
+func (t *Ws) lazyIdle() {
+	if !t.Lazy || len(t.ops) > 0 || t.lazyCancel == nil || t.idleTimer != nil {
+		return
+	}
+
+	t.logEvent(LogLevelDebug, "ws lazy idle", "timeout", t.IdleTimeout)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(t.IdleTimeout, func() {
+		t.opsm.Lock()
+		if t.idleTimer != timer || len(t.ops) > 0 {
+			t.opsm.Unlock()
+			return
+		}
+		t.idleTimer = nil
+		cancel := t.lazyStop()
+		t.opsm.Unlock()
+
+		if cancel != nil {
+			cancel()
+			t.ResetWithErr(nil)
+		}
+	})
+	t.idleTimer = timer
+}
+
+// lazyStop detaches the running connection, the returned func must be called to stop it
+// Must be called with opsm held
+func (t *Ws) lazyStop() context.CancelFunc {
+	if t.idleTimer != nil {
+		t.idleTimer.Stop()
+		t.idleTimer = nil
+	}
+
+	cancel := t.lazyCancel
+	t.lazyCancel = nil
+
+	if cancel != nil {
+		t.logEvent(LogLevelDebug, "ws lazy stop")
+	}
+
+	return cancel
+}
@@ -0,0 +1,48 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ResubscribeFunc is called before a subscription is restarted after a reconnection,
+// last is the last response received for it (nil if none), the returned variables are used to resubscribe
+type ResubscribeFunc func(variables map[string]interface{}, last *OperationResponse) map[string]interface{}
+
+type resubscribeKey struct{}
+
+// WithResubscribe opts the operations requested with the returned context into the Ws resume semantics:
+// f (which may be nil) is called before resubscribing after a reconnection, and a response for which IsResubscribed
+// returns true is delivered to signal that messages may have been missed
+func WithResubscribe(ctx context.Context, f ResubscribeFunc) context.Context {
+	return context.WithValue(ctx, resubscribeKey{}, f)
+}
+
+func resubscribeFromContext(ctx context.Context) (ResubscribeFunc, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+
+	f, ok := ctx.Value(resubscribeKey{}).(ResubscribeFunc)
+	return f, ok
+}
+
+const ResubscribedExtension = "resubscribed"
+
+func NewResubscribedOperationResponse() OperationResponse {
+	return OperationResponse{
+		Extensions: RawExtensions{
+			ResubscribedExtension: json.RawMessage(`true`),
+		},
+	}
+}
+
+// IsResubscribed returns true if opres is the marker delivered after a subscription has been restarted
+func IsResubscribed(opres OperationResponse) bool {
+	if opres.Data != nil || len(opres.Errors) > 0 {
+		return false
+	}
+
+	_, ok := opres.Extensions[ResubscribedExtension]
+	return ok
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/infiotinc/gqlgenc/client/transport"
 	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 	"os"
 	"testing"
 )
@@ -72,3 +73,42 @@ func TestChanResponse(t *testing.T) {
 
 	assert.Len(t, msgs, 3)
 }
+
+func TestLogger(t *testing.T) {
+	type entry struct {
+		level transport.LogLevel
+		msg   string
+		args  []interface{}
+	}
+	entries := make(chan entry, 10)
+
+	cli := &Client{
+		Transport: transport.Mock{
+			"query": func(req transport.Request) transport.Response {
+				return transport.NewSingleResponse(transport.NewMockOperationResponse(nil, gqlerror.List{{Message: "err"}}))
+			},
+		},
+		Logger: transport.LoggerFunc(func(ctx context.Context, level transport.LogLevel, msg string, args ...interface{}) {
+			entries <- entry{level, msg, args}
+		}),
+		LogRedaction: transport.Redaction{Variables: []string{"password"}},
+	}
+
+	var res string
+	_, err := cli.Query(context.Background(), "Login", "query", map[string]interface{}{"password": "hunter2"}, &res)
+	assert.Error(t, err)
+
+	e := <-entries
+	assert.Equal(t, transport.LogLevelDebug, e.level)
+	assert.Equal(t, "graphql operation", e.msg)
+	assert.Equal(t, []interface{}{
+		"operation", transport.Query,
+		"operationName", "Login",
+		"variables", map[string]interface{}{"password": transport.Redacted},
+	}, e.args)
+
+	e = <-entries
+	assert.Equal(t, transport.LogLevelWarn, e.level)
+	assert.Equal(t, "graphql operation done", e.msg)
+	assert.Equal(t, []interface{}{"responses", int64(1), "errors", int64(1)}, e.args[6:])
+}
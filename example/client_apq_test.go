@@ -6,6 +6,7 @@ import (
 	"github.com/infiotinc/gqlgenc/client/extensions"
 	"github.com/infiotinc/gqlgenc/client/transport"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"testing"
 )
 
@@ -38,6 +39,25 @@ func TestHttpAPQQuery(t *testing.T) {
 	})
 }
 
+func TestHttpGetAPQQuery(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, rec := httpgetcli(ctx, 0)
+	defer teardown()
+
+	cli.Use(&extensions.APQ{})
+
+	runAssertQuery(t, ctx, cli)
+	runAssertQuery(t, ctx, cli)
+
+	// Hash only, then with the query once the hash is unknown, then hash only
+	assert.Equal(t, []string{http.MethodGet, http.MethodGet, http.MethodGet}, rec.methods())
+	assert.Empty(t, rec.requests[0].URL.Query().Get("query"))
+	assert.NotEmpty(t, rec.requests[1].URL.Query().Get("query"))
+	assert.Empty(t, rec.requests[2].URL.Query().Get("query"))
+	assert.Contains(t, rec.requests[2].URL.Query().Get("extensions"), "sha256Hash")
+}
+
 func TestSplitAPQQuery(t *testing.T) {
 	ctx := context.Background()
 
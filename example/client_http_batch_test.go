@@ -0,0 +1,259 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchMiddleware runs the operations of the batches one by one, and records the batch sizes
+// The batches matching refuse are answered with a 400, and an array of errors
+type batchMiddleware struct {
+	refuse func(ops []json.RawMessage) bool
+	sizes  []int
+	m      sync.Mutex
+}
+
+func (m *batchMiddleware) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var ops []json.RawMessage
+		if err := json.Unmarshal(body, &ops); err != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		m.m.Lock()
+		m.sizes = append(m.sizes, len(ops))
+		m.m.Unlock()
+
+		if m.refuse != nil && m.refuse(ops) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`[{"errors":[{"message":"batch refused"}]}]`))
+			return
+		}
+
+		results := make([]json.RawMessage, 0, len(ops))
+		for _, op := range ops {
+			rec := httptest.NewRecorder()
+
+			opr := r.Clone(r.Context())
+			opr.Body = ioutil.NopCloser(bytes.NewReader(op))
+			opr.ContentLength = int64(len(op))
+			h.ServeHTTP(rec, opr)
+
+			results = append(results, rec.Body.Bytes())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(results)
+	})
+}
+
+func (m *batchMiddleware) batchSizes() []int {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	sizes := append([]int(nil), m.sizes...)
+	sort.Ints(sizes)
+
+	return sizes
+}
+
+func batchcli(ctx context.Context, hw func(http.Handler) http.Handler, trf func(tr *transport.Batch)) (*client.Client, func(), *transport.Batch) {
+	var tr *transport.Batch
+
+	cli, teardown := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr = &transport.Batch{
+			Http: httptr(ctx, ts.URL),
+		}
+		trf(tr)
+
+		return tr, nil
+	}, hw)
+
+	return cli, teardown, tr
+}
+
+func runConcurrentQueries(t *testing.T, ctx context.Context, cli *client.Client, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runAssertQuery(t, ctx, cli)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRawHttpBatch(t *testing.T) {
+	ctx := context.Background()
+
+	m := &batchMiddleware{}
+	cli, teardown, _ := batchcli(ctx, m.handler, func(tr *transport.Batch) {
+		tr.Window = 100 * time.Millisecond
+	})
+	defer teardown()
+
+	runConcurrentQueries(t, ctx, cli, 3)
+
+	assert.Equal(t, []int{3}, m.batchSizes())
+}
+
+func TestRawHttpBatchMaxSize(t *testing.T) {
+	ctx := context.Background()
+
+	m := &batchMiddleware{}
+	cli, teardown, _ := batchcli(ctx, m.handler, func(tr *transport.Batch) {
+		tr.Window = time.Minute
+		tr.MaxBatchSize = 2
+	})
+	defer teardown()
+
+	runConcurrentQueries(t, ctx, cli, 4)
+
+	assert.Equal(t, []int{2, 2}, m.batchSizes())
+}
+
+func TestRawHttpBatchCancel(t *testing.T) {
+	ctx := context.Background()
+
+	m := &batchMiddleware{}
+	cli, teardown, _ := batchcli(ctx, m.handler, func(tr *transport.Batch) {
+		tr.Window = 200 * time.Millisecond
+	})
+	defer teardown()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runConcurrentQueries(t, ctx, cli, 2)
+	}()
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	var data RoomQueryResponse
+	_, err := cli.Query(cctx, "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	wg.Wait()
+
+	assert.Equal(t, []int{2}, m.batchSizes())
+}
+
+// noBatchMiddleware answers the batches with a 415, as a server not supporting them
+func noBatchMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var ops []json.RawMessage
+		if err := json.Unmarshal(body, &ops); err == nil {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestRawHttpBatchFallback(t *testing.T) {
+	ctx := context.Background()
+
+	rec := &httpRequestRecorder{}
+	cli, teardown, tr := batchcli(ctx, func(h http.Handler) http.Handler {
+		return rec.middleware(noBatchMiddleware(h))
+	}, func(tr *transport.Batch) {
+		tr.Window = 100 * time.Millisecond
+	})
+	defer teardown()
+
+	runConcurrentQueries(t, ctx, cli, 2)
+
+	assert.True(t, tr.Unsupported())
+	assert.Len(t, rec.methods(), 3)
+
+	// Subsequent requests are not batched
+	runAssertQuery(t, ctx, cli)
+
+	assert.Len(t, rec.methods(), 4)
+}
+
+func TestRawHttpBatchGqlgen(t *testing.T) {
+	ctx := context.Background()
+
+	// gqlgen does not support batches, it answers them with a 400
+	rec := &httpRequestRecorder{}
+	cli, teardown, tr := batchcli(ctx, rec.middleware, func(tr *transport.Batch) {
+		tr.Window = 100 * time.Millisecond
+	})
+	defer teardown()
+
+	runConcurrentQueries(t, ctx, cli, 2)
+
+	assert.True(t, tr.Unsupported())
+	assert.Len(t, rec.methods(), 3)
+
+	// Subsequent requests are not batched
+	runConcurrentQueries(t, ctx, cli, 2)
+
+	assert.Len(t, rec.methods(), 5)
+}
+
+func TestRawHttpBatchRefused(t *testing.T) {
+	ctx := context.Background()
+
+	m := &batchMiddleware{
+		refuse: func(ops []json.RawMessage) bool {
+			for _, op := range ops {
+				if bytes.Contains(op, []byte("bad")) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+	cli, teardown, tr := batchcli(ctx, m.handler, func(tr *transport.Batch) {
+		tr.Window = 100 * time.Millisecond
+	})
+	defer teardown()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		runAssertQuery(t, ctx, cli)
+	}()
+	go func() {
+		defer wg.Done()
+
+		var data RoomQueryResponse
+		_, err := cli.Query(ctx, "", "query { bad }", nil, &data)
+		assert.Error(t, err)
+	}()
+	wg.Wait()
+
+	// Only that batch was sent individually
+	assert.False(t, tr.Unsupported())
+
+	runConcurrentQueries(t, ctx, cli, 2)
+
+	assert.Equal(t, []int{2, 2}, m.batchSizes())
+}
@@ -0,0 +1,138 @@
+package example
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func decompressReader(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case transport.EncodingGzip:
+		return gzip.NewReader(r)
+	case transport.EncodingBrotli:
+		return brotli.NewReader(r), nil
+	case transport.EncodingZstd:
+		return zstd.NewReader(r)
+	}
+
+	return r, nil
+}
+
+func compressBytes(encoding string, b []byte) []byte {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+	switch encoding {
+	case transport.EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case transport.EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	case transport.EncodingZstd:
+		w, _ = zstd.NewWriter(&buf)
+	}
+
+	_, _ = w.Write(b)
+	_ = w.Close()
+
+	return buf.Bytes()
+}
+
+// compressionMiddleware decodes the request body according to its Content-Encoding, and encodes the response
+// with the first of the Accept-Encoding
+type compressionMiddleware struct {
+	requestEncodings  []string
+	responseEncodings []string
+}
+
+func (m *compressionMiddleware) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		m.requestEncodings = append(m.requestEncodings, encoding)
+
+		body, err := decompressReader(encoding, r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(body)
+		r.Header.Del("Content-Encoding")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+
+		encoding = r.Header.Get("Accept-Encoding")
+		m.responseEncodings = append(m.responseEncodings, encoding)
+
+		data := rec.Body.Bytes()
+		if encoding != "" {
+			data = compressBytes(encoding, data)
+			w.Header().Set("Content-Encoding", encoding)
+		}
+
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(data)
+	})
+}
+
+func compressioncli(ctx context.Context, trf func(tr *transport.Http)) (*client.Client, func(), *compressionMiddleware) {
+	m := &compressionMiddleware{}
+
+	cli, teardown := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := httptr(ctx, ts.URL)
+		trf(tr)
+
+		return tr, nil
+	}, m.handler)
+
+	return cli, teardown, m
+}
+
+func TestRawHttpCompression(t *testing.T) {
+	for _, encoding := range []string{transport.EncodingGzip, transport.EncodingBrotli, transport.EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			ctx := context.Background()
+
+			cli, teardown, m := compressioncli(ctx, func(tr *transport.Http) {
+				tr.RequestCompression = encoding
+				tr.AcceptEncodings = []string{encoding}
+			})
+			defer teardown()
+
+			runAssertQuery(t, ctx, cli)
+
+			assert.Equal(t, []string{encoding}, m.requestEncodings)
+			assert.Equal(t, []string{encoding}, m.responseEncodings)
+		})
+	}
+}
+
+func TestRawHttpMaxResponseSize(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, _ := compressioncli(ctx, func(tr *transport.Http) {
+		tr.AcceptEncodings = []string{transport.EncodingGzip}
+		tr.MaxResponseSize = 10
+	})
+	defer teardown()
+
+	var data RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+	assert.True(t, errors.Is(err, transport.ErrHttpResponseTooLarge))
+	assert.EqualError(t, err, "http response too large: more than 10 bytes")
+}
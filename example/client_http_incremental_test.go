@@ -0,0 +1,149 @@
+package example
+
+import (
+	"context"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// incrementalcli serves parts as a multipart/mixed response, waiting on next before sending the following part
+// done is closed once the handler returns
+func incrementalcli(ctx context.Context, parts []string, next, done chan struct{}) (*client.Client, func()) {
+	return clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		return httptr(ctx, ts.URL), nil
+	}, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(done)
+
+			// Like incremental delivery servers, each part is directly followed by the boundary so that it can be
+			// read without waiting for the next one
+			w.Header().Set("Content-Type", `multipart/mixed; boundary="graphql"`)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("\r\n--graphql\r\n"))
+
+			for i, part := range parts {
+				_, _ = w.Write([]byte("Content-Type: application/json; charset=utf-8\r\n\r\n" + part))
+				if i == len(parts)-1 {
+					_, _ = w.Write([]byte("\r\n--graphql--\r\n"))
+				} else {
+					_, _ = w.Write([]byte("\r\n--graphql\r\n"))
+				}
+				w.(http.Flusher).Flush()
+
+				if i == len(parts)-1 {
+					return
+				}
+
+				select {
+				case <-next:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		})
+	})
+}
+
+func collectIncremental(t *testing.T, res transport.Response, next chan struct{}) []transport.OperationResponse {
+	t.Helper()
+
+	var rs []transport.OperationResponse
+	for res.Next() {
+		rs = append(rs, res.Get())
+
+		select {
+		case next <- struct{}{}:
+		default:
+		}
+	}
+	assert.NoError(t, res.Err())
+
+	return rs
+}
+
+func TestRawHttpIncremental(t *testing.T) {
+	ctx := context.Background()
+
+	next, done := make(chan struct{}, 1), make(chan struct{})
+	cli, teardown := incrementalcli(ctx, []string{
+		`{"data":{"room":{"name":"test"}},"hasNext":true}`,
+		`{"incremental":[{"data":{"hash":"abc"},"path":["room"],"label":"hash"},{"items":[{"id":"1"}],"path":["room","messages",0]}],"hasNext":true}`,
+		`{"incremental":[{"items":[{"id":"2"}],"path":["room","messages",1]}],"hasNext":false}`,
+	}, next, done)
+	defer teardown()
+
+	res := cli.IncrementalQuery(ctx, "", `query { room(name: "test") { name ... @defer(label: "hash") { hash } messages @stream { id } } }`, nil)
+	defer res.Close()
+
+	rs := collectIncremental(t, res, next)
+	if !assert.Len(t, rs, 4) {
+		return
+	}
+
+	assert.False(t, rs[0].IsIncremental())
+	assert.JSONEq(t, `{"room":{"name":"test"}}`, string(rs[0].Data))
+	assert.True(t, rs[0].HasNext)
+
+	assert.True(t, rs[1].IsIncremental())
+	assert.Equal(t, "hash", rs[1].Label)
+	assert.Equal(t, ast.Path{ast.PathName("room")}, rs[1].Path)
+	assert.JSONEq(t, `{"hash":"abc"}`, string(rs[1].Data))
+	assert.True(t, rs[1].HasNext)
+
+	assert.JSONEq(t, `[{"id":"1"}]`, string(rs[2].Items))
+	assert.Equal(t, ast.Path{ast.PathName("room"), ast.PathName("messages"), ast.PathIndex(0)}, rs[2].Path)
+	assert.True(t, rs[2].HasNext)
+
+	assert.JSONEq(t, `[{"id":"2"}]`, string(rs[3].Items))
+	assert.False(t, rs[3].HasNext)
+}
+
+func TestRawHttpIncrementalLegacyFormat(t *testing.T) {
+	ctx := context.Background()
+
+	next, done := make(chan struct{}, 1), make(chan struct{})
+	cli, teardown := incrementalcli(ctx, []string{
+		`{"data":{"room":{"name":"test"}},"hasNext":true}`,
+		`{"data":{"hash":"abc"},"path":["room"],"label":"hash","hasNext":true}`,
+		`{"hasNext":false}`,
+	}, next, done)
+	defer teardown()
+
+	res := cli.IncrementalQuery(ctx, "", `query { room(name: "test") { name ... @defer(label: "hash") { hash } } }`, nil)
+	defer res.Close()
+
+	rs := collectIncremental(t, res, next)
+	if !assert.Len(t, rs, 2) {
+		return
+	}
+
+	assert.False(t, rs[0].IsIncremental())
+	assert.True(t, rs[1].IsIncremental())
+	assert.Equal(t, "hash", rs[1].Label)
+	assert.JSONEq(t, `{"hash":"abc"}`, string(rs[1].Data))
+}
+
+func TestRawHttpIncrementalClose(t *testing.T) {
+	ctx := context.Background()
+
+	next, done := make(chan struct{}, 1), make(chan struct{})
+	cli, teardown := incrementalcli(ctx, []string{
+		`{"data":{"room":{"name":"test"}},"hasNext":true}`,
+		`{"incremental":[{"data":{"hash":"abc"},"path":["room"]}],"hasNext":false}`,
+	}, next, done)
+	defer teardown()
+
+	res := cli.IncrementalQuery(ctx, "", `query { room(name: "test") { name ... @defer { hash } } }`, nil)
+
+	assert.True(t, res.Next())
+	res.Close()
+
+	// The handler must be released by the body close
+	<-done
+	assert.False(t, res.Next())
+}
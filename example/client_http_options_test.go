@@ -0,0 +1,157 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// headerExtension sets a header on every request
+type headerExtension struct {
+	key, value string
+}
+
+func (e *headerExtension) ExtensionName() string {
+	return "header"
+}
+
+func (e *headerExtension) AroundRequest(req transport.Request, next client.RequestHandler) transport.Response {
+	req.Options = req.Options.Clone()
+	req.Options.SetHeader(e.key, e.value)
+
+	return next(req)
+}
+
+// optionscli records the requests, answers with a rate limit header, and delays the requests with a X-Delay header
+func optionscli(ctx context.Context) (*client.Client, func(), *httpRequestRecorder) {
+	rec := &httpRequestRecorder{}
+
+	cli, teardown := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		return httptr(ctx, ts.URL), nil
+	}, func(h http.Handler) http.Handler {
+		return rec.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if d, err := time.ParseDuration(r.Header.Get("X-Delay")); err == nil {
+				time.Sleep(d)
+			}
+
+			w.Header().Set("X-RateLimit-Remaining", "10")
+			h.ServeHTTP(w, r)
+		}))
+	})
+
+	return cli, teardown, rec
+}
+
+func TestRawHttpRequestOptionsContext(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, rec := optionscli(ctx)
+	defer teardown()
+
+	var opts transport.RequestOptions
+	opts.SetHeader("X-Tenant", "acme")
+	opts.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	runAssertQuery(t, transport.WithRequestOptions(ctx, opts), cli, func(opres transport.OperationResponse, data RoomQueryResponse) {
+		assert.Equal(t, "10", opres.Header.Get("X-RateLimit-Remaining"))
+	})
+
+	req := rec.requests[0]
+	assert.Equal(t, "acme", req.Header.Get("X-Tenant"))
+	if c, err := req.Cookie("session"); assert.NoError(t, err) {
+		assert.Equal(t, "abc", c.Value)
+	}
+
+	// Options are per-request
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, "", rec.requests[1].Header.Get("X-Tenant"))
+}
+
+func TestRawHttpRequestOptionsExtension(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, rec := optionscli(ctx)
+	defer teardown()
+
+	cli.Use(&headerExtension{key: "X-Tenant", value: "ext"})
+
+	var opts transport.RequestOptions
+	opts.SetHeader("X-Tenant", "ctx")
+	opts.SetHeader("Idempotency-Key", "key")
+
+	runAssertQuery(t, transport.WithRequestOptions(ctx, opts), cli)
+
+	req := rec.requests[0]
+	assert.Equal(t, []string{"ext"}, req.Header.Values("X-Tenant"))
+	assert.Equal(t, "key", req.Header.Get("Idempotency-Key"))
+}
+
+func TestRawHttpRequestOptionsTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, _ := optionscli(ctx)
+	defer teardown()
+
+	var opts transport.RequestOptions
+	opts.SetHeader("X-Delay", "500ms")
+	opts.Timeout = 50 * time.Millisecond
+
+	var data RoomQueryResponse
+	_, err := cli.Query(transport.WithRequestOptions(ctx, opts), "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// Within the timeout
+	opts.Timeout = 5 * time.Second
+
+	runAssertQuery(t, transport.WithRequestOptions(ctx, opts), cli)
+}
+
+func TestRawHttpLogger(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	var m sync.Mutex
+	logger := transport.LoggerFunc(func(ctx context.Context, level transport.LogLevel, msg string, args ...interface{}) {
+		m.Lock()
+		defer m.Unlock()
+		fmt.Fprintln(&buf, append([]interface{}{level, msg}, args...)...)
+	})
+
+	cli, teardown := clifactory(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := httptr(ctx, ts.URL)
+		tr.UseGet = true
+		tr.Logger = logger
+		tr.LogRedaction = transport.Redaction{Headers: []string{"X-Api-Key"}}
+
+		return tr, nil
+	})
+	defer teardown()
+
+	var opts transport.RequestOptions
+	opts.SetHeader("Authorization", "Bearer token")
+	opts.SetHeader("X-Api-Key", "key")
+
+	runAssertQuery(t, transport.WithRequestOptions(ctx, opts), cli)
+
+	m.Lock()
+	out := buf.String()
+	m.Unlock()
+
+	assert.Contains(t, out, "DEBUG http request method GET")
+	assert.Contains(t, out, "DEBUG http response method GET")
+	assert.Contains(t, out, "status 200")
+	assert.NotContains(t, out, "Bearer token")
+	assert.NotContains(t, out, "[key]")
+	// The query string holds the variables
+	assert.NotContains(t, out, "?query")
+}
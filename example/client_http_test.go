@@ -3,11 +3,14 @@ package example
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/infiotinc/gqlgenc/client"
 	"github.com/infiotinc/gqlgenc/client/transport"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -37,6 +40,21 @@ func TestRawHttpQueryError(t *testing.T) {
 	assert.EqualError(t, err, "input: room that's an invalid room\n")
 }
 
+func TestRawHttpInvalidQuery(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown := httpcli(ctx)
+	defer teardown()
+
+	// gqlgen answers a 422 application/json to invalid queries
+	var opres RoomQueryResponse
+	_, err := cli.Query(ctx, "", "query { nope }", nil, &opres)
+	assert.EqualError(t, err, "input:1: Cannot query field \"nope\" on type \"Query\".\n")
+
+	var herr *transport.HTTPError
+	assert.False(t, errors.As(err, &herr))
+}
+
 func TestRawHttpError(t *testing.T) {
 	ctx := context.Background()
 
@@ -56,5 +74,162 @@ func TestRawHttpError(t *testing.T) {
 
 	var opres RoomQueryResponse
 	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "name"}, &opres)
-	assert.EqualError(t, err, `no data nor errors, got 403: {"error":"Yeah that went wrong"}`)
+	assert.EqualError(t, err, `http error 403: {"error":"Yeah that went wrong"}`)
+
+	var herr *transport.HTTPError
+	if assert.True(t, errors.As(err, &herr)) {
+		assert.Equal(t, http.StatusForbidden, herr.StatusCode)
+	}
+}
+
+func httpstatuscli(ctx context.Context, status int, contentType string, body string) (*client.Client, func()) {
+	return clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		return httptr(ctx, ts.URL), nil
+	}, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		})
+	})
+}
+
+func TestRawHttpStatusWithGraphqlErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// An intermediary can't be trusted to send a GraphQL response, even if it looks like one
+	cli, teardown := httpstatuscli(ctx, http.StatusServiceUnavailable, transport.JSONContentType, `{"errors":[{"message":"unavailable"}]}`)
+	defer teardown()
+
+	var opres RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "name"}, &opres)
+
+	var herr *transport.HTTPError
+	if assert.True(t, errors.As(err, &herr)) {
+		assert.Equal(t, http.StatusServiceUnavailable, herr.StatusCode)
+		assert.Equal(t, "10", herr.Header.Get("Retry-After"))
+	}
+}
+
+func TestRawHttpGraphqlResponseRequestError(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown := httpstatuscli(ctx, http.StatusBadRequest, transport.GraphQLResponseContentType, `{"errors":[{"message":"invalid query"}]}`)
+	defer teardown()
+
+	var opres RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "name"}, &opres)
+	assert.EqualError(t, err, "input: invalid query\n")
+
+	var herr *transport.HTTPError
+	assert.False(t, errors.As(err, &herr))
+}
+
+func TestRawHttpGraphqlResponseMalformed(t *testing.T) {
+	ctx := context.Background()
+
+	body := strings.Repeat("x", 2000)
+	cli, teardown := httpstatuscli(ctx, http.StatusUnauthorized, transport.GraphQLResponseContentType, body)
+	defer teardown()
+
+	var opres RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "name"}, &opres)
+
+	var herr *transport.HTTPError
+	if assert.True(t, errors.As(err, &herr)) {
+		assert.Equal(t, http.StatusUnauthorized, herr.StatusCode)
+		assert.Len(t, herr.Body, 1000)
+	}
+}
+
+func TestRawHttpAccept(t *testing.T) {
+	ctx := context.Background()
+
+	rec := &httpRequestRecorder{}
+	cli, teardown := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		return httptr(ctx, ts.URL), nil
+	}, rec.middleware)
+	defer teardown()
+
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, "multipart/mixed;deferSpec=20220824, application/graphql-response+json, application/json;q=0.9", rec.requests[0].Header.Get("Accept"))
+}
+
+type httpRequestRecorder struct {
+	requests []*http.Request
+	m        sync.Mutex
+}
+
+func (r *httpRequestRecorder) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.m.Lock()
+		r.requests = append(r.requests, req)
+		r.m.Unlock()
+
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (r *httpRequestRecorder) methods() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	methods := make([]string, 0, len(r.requests))
+	for _, req := range r.requests {
+		methods = append(methods, req.Method)
+	}
+
+	return methods
+}
+
+func httpgetcli(ctx context.Context, maxGetURLLength int) (*client.Client, func(), *httpRequestRecorder) {
+	rec := &httpRequestRecorder{}
+
+	cli, teardown := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := httptr(ctx, ts.URL)
+		tr.UseGet = true
+		tr.MaxGetURLLength = maxGetURLLength
+
+		return tr, nil
+	}, rec.middleware)
+
+	return cli, teardown, rec
+}
+
+func TestRawHttpGetQuery(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, rec := httpgetcli(ctx, 0)
+	defer teardown()
+
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, []string{http.MethodGet}, rec.methods())
+	assert.Equal(t, `{"name":"test"}`, rec.requests[0].URL.Query().Get("variables"))
+}
+
+func TestRawHttpGetFallbackToPost(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, rec := httpgetcli(ctx, 50)
+	defer teardown()
+
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, []string{http.MethodPost}, rec.methods())
+}
+
+func TestRawHttpGetMutationIsPosted(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown, rec := httpgetcli(ctx, 0)
+	defer teardown()
+
+	var data map[string]interface{}
+	_, err := cli.Mutation(ctx, "", `mutation { post(input: {text: "some text"}) { id } }`, nil, &data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{http.MethodPost}, rec.methods())
 }
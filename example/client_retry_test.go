@@ -0,0 +1,229 @@
+package example
+
+import (
+	"context"
+	"errors"
+	"github.com/infiotinc/gqlgenc/client"
+	"github.com/infiotinc/gqlgenc/client/extensions"
+	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingMiddleware fails the first failures requests with status, or with a GraphQL error if status is 200,
+// or by closing the connection if drop is set
+type failingMiddleware struct {
+	failures   int32
+	status     int
+	retryAfter string
+	drop       bool
+
+	requests int32
+}
+
+func (m *failingMiddleware) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&m.requests, 1)
+		if n > m.failures {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if m.drop {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+
+		if m.retryAfter != "" {
+			w.Header().Set("Retry-After", m.retryAfter)
+		}
+
+		if m.status == http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"errors":[{"message":"unavailable","extensions":{"code":"UNAVAILABLE"}}]}`))
+			return
+		}
+
+		w.WriteHeader(m.status)
+		_, _ = w.Write([]byte("failure"))
+	})
+}
+
+func (m *failingMiddleware) requestCount() int {
+	return int(atomic.LoadInt32(&m.requests))
+}
+
+func retrycli(ctx context.Context, m *failingMiddleware, retry *extensions.Retry) (*client.Client, func()) {
+	cli, teardown := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		return httptr(ctx, ts.URL), nil
+	}, m.handler)
+
+	if retry.Backoff == nil {
+		retry.Backoff = transport.ConstantBackoff(10 * time.Millisecond)
+	}
+	cli.Use(retry)
+
+	return cli, teardown
+}
+
+func runMutation(ctx context.Context, cli *client.Client) error {
+	var data CreatePostMutationResponse
+	_, err := cli.Mutation(ctx, "", `mutation { post(input: {text: "some text"}) { id } }`, nil, &data)
+
+	return err
+}
+
+type CreatePostMutationResponse struct {
+	Post struct {
+		ID string `json:"id"`
+	} `json:"post"`
+}
+
+func TestRetryQuery(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 2, status: http.StatusServiceUnavailable}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{})
+	defer teardown()
+
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, 3, m.requestCount())
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 5, status: http.StatusBadGateway}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{MaxAttempts: 2})
+	defer teardown()
+
+	var data RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+
+	var herr *transport.HTTPError
+	if assert.True(t, errors.As(err, &herr)) {
+		assert.Equal(t, http.StatusBadGateway, herr.StatusCode)
+	}
+	assert.Equal(t, 2, m.requestCount())
+}
+
+func TestRetryStatusNotRetried(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 1, status: http.StatusForbidden}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{})
+	defer teardown()
+
+	var data RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+	assert.Error(t, err)
+	assert.Equal(t, 1, m.requestCount())
+}
+
+func TestRetryCodes(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 1, status: http.StatusOK}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{RetryCodes: []string{"UNAVAILABLE"}})
+	defer teardown()
+
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, 2, m.requestCount())
+}
+
+func TestRetryMutation(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 1, status: http.StatusServiceUnavailable}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{})
+	defer teardown()
+
+	// Not marked safe
+	err := runMutation(ctx, cli)
+	assert.Error(t, err)
+	assert.Equal(t, 1, m.requestCount())
+
+	atomic.StoreInt32(&m.requests, 0)
+
+	err = runMutation(extensions.WithRetrySafe(ctx), cli)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, m.requestCount())
+}
+
+func TestRetryAfter(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 1, status: http.StatusTooManyRequests, retryAfter: "1"}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{})
+	defer teardown()
+
+	start := time.Now()
+	runAssertQuery(t, ctx, cli)
+
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, 2, m.requestCount())
+}
+
+func TestRetryAfterDeadline(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 1, status: http.StatusTooManyRequests, retryAfter: "10"}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{})
+	defer teardown()
+
+	dctx, dcancel := context.WithTimeout(ctx, time.Second)
+	defer dcancel()
+
+	var data RoomQueryResponse
+	_, err := cli.Query(dctx, "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+
+	// Retrying would end after the deadline, the failure is returned right away
+	var herr *transport.HTTPError
+	if assert.True(t, errors.As(err, &herr)) {
+		assert.Equal(t, http.StatusTooManyRequests, herr.StatusCode)
+	}
+	assert.Equal(t, 1, m.requestCount())
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{failures: 1, drop: true}
+	cli, teardown := retrycli(ctx, m, &extensions.Retry{})
+	defer teardown()
+
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, 2, m.requestCount())
+}
+
+func TestRetryResponseTooLarge(t *testing.T) {
+	ctx := context.Background()
+
+	m := &failingMiddleware{}
+	cli, teardown := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := httptr(ctx, ts.URL)
+		tr.MaxResponseSize = 10
+
+		return tr, nil
+	}, m.handler)
+	defer teardown()
+
+	cli.Use(&extensions.Retry{Backoff: transport.ConstantBackoff(10 * time.Millisecond)})
+
+	var data RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+
+	// Would fail again, not retried
+	assert.True(t, errors.Is(err, transport.ErrHttpResponseTooLarge))
+	assert.Equal(t, 1, m.requestCount())
+}
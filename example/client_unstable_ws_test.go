@@ -2,11 +2,15 @@ package example
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/infiotinc/gqlgenc/client"
 	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
 	"net/http/httptest"
 	"nhooyr.io/websocket"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -37,6 +41,10 @@ func (u *unstableWebsocketConn) SetReadLimit(limit int64) {
 	u.wsconn.SetReadLimit(limit)
 }
 
+func (u *unstableWebsocketConn) Subprotocol() string {
+	return u.wsconn.Subprotocol()
+}
+
 func newUnstableConn(ctx context.Context, URL string) (transport.WebsocketConn, error) {
 	wsconn, err := transport.DefaultWebsocketConnProvider(time.Second)(ctx, URL)
 	if err != nil {
@@ -92,3 +100,148 @@ func TestRawWSUnstableSubscription(t *testing.T) {
 		runAssertSub(t, ctx, cli)
 	}
 }
+
+func TestRawWSUnstableHooks(t *testing.T) {
+	ctx := context.Background()
+
+	var connects, acks, keepAlives, disconnects, reconnects int32
+
+	cli, teardown := clifactory(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := &transport.Ws{
+			URL:                   "ws" + strings.TrimPrefix(ts.URL, "http"),
+			WebsocketConnProvider: newUnstableConn,
+			Hooks: transport.WsHooks{
+				OnConnect: func() {
+					atomic.AddInt32(&connects, 1)
+				},
+				OnAck: func(payload json.RawMessage) {
+					atomic.AddInt32(&acks, 1)
+				},
+				OnKeepAlive: func() {
+					atomic.AddInt32(&keepAlives, 1)
+				},
+				OnDisconnect: func(err error) {
+					atomic.AddInt32(&disconnects, 1)
+				},
+				OnReconnect: func(attempt int) {
+					assert.Equal(t, 1, attempt)
+					atomic.AddInt32(&reconnects, 1)
+				},
+			},
+		}
+		tr.Start(ctx)
+		tr.WaitFor(transport.StatusReady, time.Second)
+
+		return tr, func() {
+			tr.Close()
+		}
+	})
+	defer teardown()
+	tr := cli.Transport.(*transport.Ws)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connects))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&acks))
+	assert.NotZero(t, atomic.LoadInt32(&keepAlives))
+
+	tr.GetConn().(*unstableWebsocketConn).dropConn()
+
+	tr.WaitFor(transport.StatusReady, time.Second)
+
+	runAssertQuery(t, ctx, cli)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&connects))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&acks))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&disconnects))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reconnects))
+}
+
+type mutedWebsocketConn struct {
+	transport.WebsocketConn
+	muted int32
+}
+
+func (c *mutedWebsocketConn) mute() {
+	fmt.Println("## MUTE CONN")
+	atomic.StoreInt32(&c.muted, 1)
+}
+
+// ReadJSON discards all messages once muted, as if the server went silent
+func (c *mutedWebsocketConn) ReadJSON(v interface{}) error {
+	for {
+		err := c.WebsocketConn.ReadJSON(v)
+		if err != nil || atomic.LoadInt32(&c.muted) == 0 {
+			return err
+		}
+	}
+}
+
+func (c *mutedWebsocketConn) Subprotocol() string {
+	return c.WebsocketConn.(transport.WebsocketConnSubprotocol).Subprotocol()
+}
+
+func runKeepAliveTimeout(t *testing.T, subprotocol transport.WsSubprotocol, pingInterval time.Duration) {
+	ctx := context.Background()
+
+	disconnectErrs := make(chan error, 10)
+	var keepAlives int32
+
+	cli, teardown := clifactory(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := &transport.Ws{
+			URL: "ws" + strings.TrimPrefix(ts.URL, "http"),
+			WebsocketConnProvider: func(ctx context.Context, URL string) (transport.WebsocketConn, error) {
+				conn, err := transport.DefaultWebsocketConnProvider(0, func(o *websocket.DialOptions) {
+					o.Subprotocols = []string{string(subprotocol)}
+				})(ctx, URL)
+				if err != nil {
+					return nil, err
+				}
+
+				return &mutedWebsocketConn{WebsocketConn: conn}, nil
+			},
+			KeepAliveTimeoutFactor: 3,
+			PingInterval:           pingInterval,
+			Hooks: transport.WsHooks{
+				OnKeepAlive: func() {
+					atomic.AddInt32(&keepAlives, 1)
+				},
+				OnDisconnect: func(err error) {
+					disconnectErrs <- err
+				},
+			},
+		}
+		tr.Start(ctx)
+		tr.WaitFor(transport.StatusReady, time.Second)
+
+		return tr, func() {
+			tr.Close()
+		}
+	})
+	defer teardown()
+	tr := cli.Transport.(*transport.Ws)
+
+	// Healthy connection is kept alive
+	time.Sleep(2 * time.Second)
+	assert.NotZero(t, atomic.LoadInt32(&keepAlives))
+	assert.Len(t, disconnectErrs, 0)
+
+	tr.GetConn().(*mutedWebsocketConn).mute()
+
+	select {
+	case err := <-disconnectErrs:
+		assert.Equal(t, transport.ErrWsKeepAliveTimeout, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("keep alive timeout not detected")
+	}
+
+	tr.WaitFor(transport.StatusReady, time.Second)
+
+	runAssertQuery(t, ctx, cli)
+}
+
+func TestRawWSKeepAliveTimeout(t *testing.T) {
+	runKeepAliveTimeout(t, transport.SubprotocolGraphqlWs, 0)
+}
+
+func TestRawTransportWSKeepAliveTimeout(t *testing.T) {
+	runKeepAliveTimeout(t, transport.SubprotocolGraphqlTransportWs, 200*time.Millisecond)
+}
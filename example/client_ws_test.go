@@ -2,9 +2,12 @@ package example
 
 import (
 	"context"
+	"errors"
 	"github.com/infiotinc/gqlgenc/client"
 	"github.com/infiotinc/gqlgenc/client/transport"
+	"github.com/stretchr/testify/assert"
 	"net/http/httptest"
+	"nhooyr.io/websocket"
 	"testing"
 	"time"
 )
@@ -60,3 +63,89 @@ func TestWSCtxCancel2(t *testing.T) {
 	time.Sleep(time.Second)
 	teardown()
 }
+
+func transportwscli(ctx context.Context) (*client.Client, func()) {
+	return clifactory(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := cwstr(ctx, ts.URL, transport.DefaultWebsocketConnProvider(30*time.Second, func(o *websocket.DialOptions) {
+			o.Subprotocols = []string{string(transport.SubprotocolGraphqlTransportWs)}
+		}))
+
+		return tr, func() {
+			tr.Close()
+		}
+	})
+}
+
+func TestRawTransportWSQuery(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown := transportwscli(ctx)
+	defer teardown()
+
+	assert.Equal(t, string(transport.SubprotocolGraphqlTransportWs), cli.Transport.(*transport.Ws).GetConn().(*transport.WebsocketHandler).Subprotocol())
+
+	runAssertQuery(t, ctx, cli)
+}
+
+func TestRawTransportWSSubscription(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown := transportwscli(ctx)
+	defer teardown()
+
+	runAssertSub(t, ctx, cli)
+}
+
+func TestRawTransportWSQueryError(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown := transportwscli(ctx)
+	defer teardown()
+
+	var opres RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "error"}, &opres)
+	assert.EqualError(t, err, "input: room that's an invalid room\n")
+}
+
+func TestRawTransportWSInvalidQuery(t *testing.T) {
+	ctx := context.Background()
+
+	cli, teardown := transportwscli(ctx)
+	defer teardown()
+
+	var opres RoomQueryResponse
+	_, err := cli.Query(ctx, "", "query { nope }", nil, &opres)
+	assert.EqualError(t, err, "input:1: Cannot query field \"nope\" on type \"Query\".\n")
+}
+
+func TestWSReconnectBackoffExhausted(t *testing.T) {
+	ctx := context.Background()
+
+	tr := &transport.Ws{
+		URL: "ws://127.0.0.1:1",
+		ReconnectBackoff: &transport.ExponentialBackoff{
+			InitialInterval: 10 * time.Millisecond,
+			MaxAttempts:     3,
+		},
+	}
+	errCh := tr.Start(ctx)
+	defer tr.Close()
+
+	cli := &client.Client{
+		Transport: tr,
+	}
+
+	res := cli.Subscription(ctx, "", MessagesSub, nil)
+	for res.Next() {
+		t.Fatal("should not receive any message")
+	}
+	assert.True(t, errors.Is(res.Err(), transport.ErrWsReconnectAborted))
+
+	for range errCh {
+		// Wait for the transport to stop
+	}
+
+	var data RoomQueryResponse
+	_, err := cli.Query(ctx, "", RoomQuery, map[string]interface{}{"name": "test"}, &data)
+	assert.True(t, errors.Is(err, transport.ErrWsReconnectAborted))
+}
@@ -1,16 +1,24 @@
 package example
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"example/client"
 	client2 "github.com/infiotinc/gqlgenc/client"
 	"github.com/infiotinc/gqlgenc/client/transport"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSubscription(t *testing.T) {
@@ -207,6 +215,55 @@ func uploadcli(ctx context.Context) (*client2.Client, func()) {
 	})
 }
 
+// uploadRecorder records the multipart requests map and file parts
+type uploadRecorder struct {
+	contentLength int64
+	filesMap      map[string][]string
+	parts         map[string]string // name -> content type
+}
+
+func (u *uploadRecorder) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		u.contentLength = r.ContentLength
+		u.parts = map[string]string{}
+
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+
+			switch part.FormName() {
+			case "operations":
+			case "map":
+				_ = json.NewDecoder(part).Decode(&u.filesMap)
+			default:
+				u.parts[part.FormName()] = part.Header.Get("Content-Type")
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func uploadrecordercli(ctx context.Context) (*client2.Client, func(), *uploadRecorder) {
+	rec := &uploadRecorder{}
+
+	cli, td := clifactorywith(ctx, func(ts *httptest.Server) (transport.Transport, func()) {
+		tr := httptr(ctx, ts.URL)
+		tr.UseFormMultipart = true
+
+		return tr, nil
+	}, rec.middleware)
+
+	return cli, td, rec
+}
+
 func createUploadFile(t *testing.T) (transport.Upload, int64, func()) {
 	f, err := ioutil.TempFile("", "")
 	if err != nil {
@@ -306,6 +363,137 @@ func TestMutationUploadFilesMap(t *testing.T) {
 	assert.Equal(t, l, res.UploadFilesMap.Somefile.Size)
 }
 
+func TestMutationUploadFileContentType(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cli, td, rec := uploadrecordercli(ctx)
+	defer td()
+
+	gql := &client.Client{
+		Client: cli,
+	}
+
+	up, l, rm := createUploadFile(t)
+	defer rm()
+	up.ContentType = "text/plain"
+
+	res, _, err := gql.UploadFile(ctx, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, l, res.UploadFile.Size)
+	assert.Equal(t, map[string]string{"0": "text/plain"}, rec.parts)
+	assert.Greater(t, rec.contentLength, l)
+}
+
+func TestMutationUploadFilesDedup(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cli, td, rec := uploadrecordercli(ctx)
+	defer td()
+
+	gql := &client.Client{
+		Client: cli,
+	}
+
+	up, l, rm := createUploadFile(t)
+	defer rm()
+
+	res, _, err := gql.UploadFiles(ctx, []*transport.Upload{&up, &up})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, res.UploadFiles, 2)
+	for _, f := range res.UploadFiles {
+		assert.Equal(t, l, f.Size)
+	}
+	assert.Equal(t, map[string][]string{"0": {"variables.files.0", "variables.files.1"}}, rec.filesMap)
+	assert.Len(t, rec.parts, 1)
+}
+
+func TestMutationUploadFileReplay(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cli, td := uploadcli(ctx)
+	defer td()
+
+	gql := &client.Client{
+		Client: cli,
+	}
+
+	up, l, rm := createUploadFile(t)
+	defer rm()
+
+	assert.True(t, up.Replayable())
+
+	for i := 0; i < 2; i++ {
+		res, _, err := gql.UploadFile(ctx, up)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, l, res.UploadFile.Size)
+	}
+}
+
+func TestMutationUploadFileProgress(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cli, td := uploadcli(ctx)
+	defer td()
+
+	gql := &client.Client{
+		Client: cli,
+	}
+
+	up, l, rm := createUploadFile(t)
+	defer rm()
+
+	var sent int64
+	up.Progress = func(n int64) {
+		sent = n
+	}
+
+	res, _, err := gql.UploadFile(ctx, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, l, res.UploadFile.Size)
+	assert.Equal(t, l, sent)
+}
+
+func TestMutationUploadFileCancel(t *testing.T) {
+	t.Parallel()
+
+	cli, td := uploadcli(context.Background())
+	defer td()
+
+	gql := &client.Client{
+		Client: cli,
+	}
+
+	// Never ending upload
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, _, err := gql.UploadFile(ctx, transport.Upload{Name: "file", File: pr})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+}
+
 func TestIssue8(t *testing.T) {
 	t.Parallel()
 
@@ -32,8 +32,6 @@ func wstr(ctx context.Context, u string) *transport.Ws {
 }
 
 func cwstr(ctx context.Context, u string, newWebsocketConn transport.WebsocketConnProvider) *transport.Ws {
-	_ = os.Setenv("GQLGENC_WS_LOG", "1")
-
 	if strings.HasPrefix(u, "http") {
 		u = "ws" + strings.TrimPrefix(u, "http")
 	}
@@ -41,6 +39,7 @@ func cwstr(ctx context.Context, u string, newWebsocketConn transport.WebsocketCo
 	tr := &transport.Ws{
 		URL:                   u,
 		WebsocketConnProvider: newWebsocketConn,
+		Logger:                transport.NewStdLogger(log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds), transport.LogLevelDebug),
 	}
 	errCh := tr.Start(ctx)
 	go func() {
@@ -73,6 +72,7 @@ func clifactorywith(ctx context.Context, trf func(server *httptest.Server) (tran
 		Resolvers: &server.Resolver{},
 	}))
 
+	h.AddTransport(htransport.GET{})
 	h.AddTransport(htransport.POST{})
 	h.AddTransport(htransport.MultipartForm{})
 	h.AddTransport(htransport.Websocket{
@@ -6,8 +6,10 @@ go 1.15
 
 require (
 	github.com/99designs/gqlgen v0.16.0
+	github.com/andybalholm/brotli v1.0.4
 	github.com/gorilla/websocket v1.4.2
 	github.com/infiotinc/gqlgenc v0.0.0-00010101000000-000000000000
+	github.com/klauspost/compress v1.13.6
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.4.0
 	github.com/urfave/cli/v2 v2.3.0
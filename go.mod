@@ -4,7 +4,9 @@ go 1.15
 
 require (
 	github.com/99designs/gqlgen v0.16.0
+	github.com/andybalholm/brotli v1.0.4
 	github.com/google/go-cmp v0.5.4 // indirect
+	github.com/klauspost/compress v1.13.6
 	github.com/stretchr/testify v1.4.0
 	github.com/vektah/gqlparser/v2 v2.2.0
 	gopkg.in/yaml.v2 v2.3.0